@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/fs"
 	"main/internal/dagger"
+	"maps"
 	"path/filepath"
 	"regexp"
 )
@@ -22,6 +23,8 @@ type analyzer struct {
 type PatternMatch struct {
 	Match    bool
 	Patterns []string
+	// The path of the matched files relative to the analyzed directory
+	Files []string
 }
 
 func newAnalyzer(dir *dagger.Directory, patternExclusions []string, patternMatches map[string]PatternMatch) (*analyzer, error) {
@@ -31,8 +34,9 @@ func newAnalyzer(dir *dagger.Directory, patternExclusions []string, patternMatch
 
 	return &analyzer{
 		PatternExclusions: patternExclusions,
-		PatternMatches:    patternMatches,
-		dir:               dir,
+		// The patterns are copied since the matches are recorded on them
+		PatternMatches: maps.Clone(patternMatches),
+		dir:            dir,
 	}, nil
 }
 
@@ -64,6 +68,13 @@ func (a *analyzer) run(ctx context.Context, internalImage string) error {
 				re := regexp.MustCompile(pattern)
 				if re.MatchString(d.Name()) {
 					patternMatch.Match = true
+					if !d.IsDir() {
+						relPath, err := filepath.Rel(analyzeFolder, path)
+						if err != nil {
+							return err
+						}
+						patternMatch.Files = append(patternMatch.Files, relPath)
+					}
 					a.PatternMatches[k] = patternMatch
 					break
 				}
@@ -74,6 +85,10 @@ func (a *analyzer) run(ctx context.Context, internalImage string) error {
 	})
 }
 
+func (a *analyzer) getMatchedFiles(key string) []string {
+	return a.PatternMatches[key].Files
+}
+
 func (a *analyzer) getMatch() []string {
 	var matched []string
 
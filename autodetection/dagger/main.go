@@ -23,8 +23,11 @@ func (a *Autodetection) Node(
 	// Define patterns to exclude from the analysis
 	// +optional
 	patternExclusions []string,
+	// A node version index (same format as https://nodejs.org/dist/index.json) to resolve engine ranges, the bundled one is used by default
+	// +optional
+	nodeVersionIndex *dagger.File,
 ) (*NodeAnalyzer, error) {
-	return newNodeAnalyzer(ctx, src, patternExclusions, internalImage)
+	return newNodeAnalyzer(ctx, src, patternExclusions, internalImage, nodeVersionIndex)
 }
 
 // Expose OCI dection runtime information
@@ -2,15 +2,32 @@ package main
 
 import (
 	"context"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/exp/maps"
+	"io/fs"
 	"main/internal/dagger"
 	"os"
 	"regexp"
 	"slices"
+	"strings"
 )
 
+// The offline node version index bundled with the module, same format as https://nodejs.org/dist/index.json
+//
+//go:embed node-versions.json
+var defaultNodeVersionIndex string
+
+var yarnNodeLinkerRe = regexp.MustCompile(`(?m)^nodeLinker:\s*["']?([\w-]+)`)
+
+// Files used by version managers to pin the node version, in order of precedence
+var nodeVersionFiles = []string{
+	".nvmrc",
+	".node-version",
+}
+
 var defaultNodeExclude = []string{
 	"node_modules",
 	".tsconfig",
@@ -19,10 +36,7 @@ var defaultNodeExclude = []string{
 var defaultNodePatterns = map[string]PatternMatch{
 	"test": {
 		Patterns: []string{
-			".+\\.(test|spec)\\.js",
-			".+\\.(test|spec)\\.jsx",
-			".+\\.(test|spec)\\.ts",
-			".+\\.(test|spec)\\.tsx",
+			".+\\.(test|spec)\\.[cm]?[jt]sx?$",
 			"(.+/)*(__)*tests*(__)*/.+",
 		},
 	},
@@ -36,17 +50,58 @@ var defaultNodePatterns = map[string]PatternMatch{
 			".*package-lock.json",
 		},
 	},
+	"pnpm": {
+		Patterns: []string{
+			".*pnpm-lock.yaml",
+		},
+	},
+	"bun": {
+		Patterns: []string{
+			".*bun.lockb",
+			".*bun.lock",
+		},
+	},
+	"native": {
+		Patterns: []string{
+			".*binding.gyp",
+		},
+	},
+}
+
+// The packages compiling a native addon when no prebuilt binary matches the platform
+var knownNativePackages = []string{
+	"argon2",
+	"bcrypt",
+	"better-sqlite3",
+	"bufferutil",
+	"canvas",
+	"cpu-features",
+	"deasync",
+	"leveldown",
+	"microtime",
+	"node-gyp",
+	"node-pty",
+	"node-sass",
+	"re2",
+	"serialport",
+	"sharp",
+	"sqlite3",
+	"usb",
+	"utf-8-validate",
+	"zeromq",
 }
 
 type packageJson struct {
 	Name            string            `json:"name"`
 	Version         string            `json:"version"`
 	Description     string            `json:"description"`
-	Workspaces      []string          `json:"workspaces"`
+	Workspaces      workspaceGlobs    `json:"workspaces"`
+	PackageManager  string            `json:"packageManager,omitempty"`
 	Scripts         map[string]string `json:"scripts,omitempty"`
 	Dependencies    map[string]string `json:"dependencies"`
 	DevDependencies map[string]string `json:"devDependencies"`
 	Repository      *repository       `json:"repository"`
+	License         license           `json:"license"`
 	Engines         *engines          `json:"engines"`
 	PublishConfig   *publishConfig    `json:"publishConfig,omitempty"`
 }
@@ -56,6 +111,40 @@ type repository struct {
 	URL  string `json:"url"`
 }
 
+// UnmarshalJSON accept the shorthand string form of the repository (e.g. "github:user/repo")
+func (r *repository) UnmarshalJSON(data []byte) error {
+	var url string
+	if json.Unmarshal(data, &url) == nil {
+		r.URL = url
+		return nil
+	}
+
+	type plain repository
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+type license string
+
+// UnmarshalJSON accept the deprecated object form of the license (e.g. {"type": "MIT"})
+func (l *license) UnmarshalJSON(data []byte) error {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		*l = license(value)
+		return nil
+	}
+
+	var object struct {
+		Type string `json:"type"`
+	}
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return err
+	}
+
+	*l = license(object.Type)
+	return nil
+}
+
 type engines struct {
 	Node string `json:"node"`
 }
@@ -65,11 +154,21 @@ type publishConfig struct {
 }
 
 type NodeAnalyzer struct {
-	Matches    []string
-	PkgJsonRep string
+	Matches            []string
+	TestFiles          []string
+	PkgJsonRep         string
+	VersionIndexRep    string
+	NodeVersionFileRep string
+	YarnrcRep          string
+	WorkspaceGlobs     []string
+	WorkspacePackages  []WorkspacePackage
+	// +private
+	Dir *dagger.Directory
+	// +private
+	InternalImage string
 }
 
-func newNodeAnalyzer(ctx context.Context, dir *dagger.Directory, patternExclusions []string, internalImage string) (*NodeAnalyzer, error) {
+func newNodeAnalyzer(ctx context.Context, dir *dagger.Directory, patternExclusions []string, internalImage string, nodeVersionIndex *dagger.File) (*NodeAnalyzer, error) {
 	anlzr, err := newAnalyzer(
 		dir,
 		append(patternExclusions, defaultNodeExclude...),
@@ -89,12 +188,81 @@ func newNodeAnalyzer(ctx context.Context, dir *dagger.Directory, patternExclusio
 		return nil, err
 	}
 
+	versionIndex := defaultNodeVersionIndex
+	if nodeVersionIndex != nil {
+		versionIndex, err = nodeVersionIndex.Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nodeVersionFile, err := readNodeVersionFile()
+	if err != nil {
+		return nil, err
+	}
+
+	// The .yarnrc.yml file is only used by yarn berry (v2+)
+	yarnrc, err := os.ReadFile(analyzeFolder + "/.yarnrc.yml")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	pkgJson := packageJson{}
+	err = json.Unmarshal(content, &pkgJson)
+	if err != nil {
+		return nil, err
+	}
+
+	workspaceGlobs := []string(pkgJson.Workspaces)
+	if len(workspaceGlobs) == 0 {
+		workspaceGlobs, err = readPnpmWorkspaceGlobs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	workspacePackages, err := resolveWorkspacePackages(workspaceGlobs)
+	if err != nil {
+		return nil, err
+	}
+
 	return &NodeAnalyzer{
-		Matches:    anlzr.getMatch(),
-		PkgJsonRep: string(content),
+		Matches:            anlzr.getMatch(),
+		TestFiles:          anlzr.getMatchedFiles("test"),
+		PkgJsonRep:         string(content),
+		VersionIndexRep:    versionIndex,
+		NodeVersionFileRep: nodeVersionFile,
+		YarnrcRep:          string(yarnrc),
+		WorkspaceGlobs:     workspaceGlobs,
+		WorkspacePackages:  workspacePackages,
+		Dir:                dir,
+		InternalImage:      internalImage,
 	}, nil
 }
 
+// readNodeVersionFile return the version spec from the first '.nvmrc' or '.node-version' file found at the root of the project
+func readNodeVersionFile() (string, error) {
+	for _, name := range nodeVersionFiles {
+		content, err := os.ReadFile(analyzeFolder + "/" + name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		for _, line := range strings.Split(string(content), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			line = strings.TrimSpace(line)
+			if line != "" {
+				return line, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
 func (n NodeAnalyzer) toPkgJson() (*packageJson, error) {
 	pkgJson := packageJson{}
 	err := json.Unmarshal([]byte(n.PkgJsonRep), &pkgJson)
@@ -109,14 +277,47 @@ func (n *NodeAnalyzer) IsTest() bool {
 	return slices.Contains(n.Matches, "test")
 }
 
+// Return the test files found in the project
+func (n *NodeAnalyzer) GetTestFiles() []string {
+	return n.TestFiles
+}
+
 func (n *NodeAnalyzer) IsYarn() bool {
 	return slices.Contains(n.Matches, "yarn")
 }
 
+// Define if the project uses yarn berry (v2+) based on the presence of a .yarnrc.yml file
+func (n *NodeAnalyzer) IsYarnBerry() bool {
+	return n.IsYarn() && n.YarnrcRep != ""
+}
+
+// Return the yarn berry install strategy (pnp | node-modules | pnpm), empty if the project doesn't use yarn berry
+func (n *NodeAnalyzer) GetYarnNodeLinker() string {
+	if !n.IsYarnBerry() {
+		return ""
+	}
+
+	nodeLinker := yarnNodeLinkerRe.FindStringSubmatch(n.YarnrcRep)
+	if nodeLinker == nil {
+		// Plug'n'Play is the default install strategy of yarn berry
+		return "pnp"
+	}
+
+	return nodeLinker[1]
+}
+
 func (n *NodeAnalyzer) IsNpm() bool {
 	return slices.Contains(n.Matches, "npm")
 }
 
+func (n *NodeAnalyzer) IsPnpm() bool {
+	return slices.Contains(n.Matches, "pnpm")
+}
+
+func (n *NodeAnalyzer) IsBun() bool {
+	return slices.Contains(n.Matches, "bun")
+}
+
 func (n *NodeAnalyzer) IsPackage() (bool, error) {
 	info, err := n.toPkgJson()
 	if err != nil {
@@ -138,24 +339,23 @@ func (n *NodeAnalyzer) Is(
 	return slices.Contains(scriptNames, scriptName), nil
 }
 
+// Return the newest node version matching the 'engines.node' range, or the '.nvmrc' / '.node-version' file as fallback
 func (n *NodeAnalyzer) GetEngineVersion() (string, error) {
 	info, err := n.toPkgJson()
 	if err != nil {
 		return "", err
 	}
 
-	if info.Engines == nil {
-		return "", fmt.Errorf("no engines found, more details: https://docs.npmjs.com/cli/v7/configuring-npm/package-json#engines")
+	spec := n.NodeVersionFileRep
+	if info.Engines != nil && info.Engines.Node != "" {
+		spec = info.Engines.Node
 	}
 
-	nodeEngineVersion := regexp.
-		MustCompile(`(\d+\.\d+\.\d+)`).
-		FindStringSubmatch(info.Engines.Node)
-	if len(nodeEngineVersion) != 2 {
-		return "", fmt.Errorf("not able to parse the node engine version: '%s'", info.Engines.Node)
+	if spec == "" {
+		return "", fmt.Errorf("no engines found, more details: https://docs.npmjs.com/cli/v7/configuring-npm/package-json#engines")
 	}
 
-	return nodeEngineVersion[1], nil
+	return resolveNodeVersion(spec, n.VersionIndexRep)
 }
 
 func (n *NodeAnalyzer) GetVersion() (string, error) {
@@ -176,20 +376,214 @@ func (n *NodeAnalyzer) GetName() (string, error) {
 	return info.Name, nil
 }
 
-func (n *NodeAnalyzer) GetScriptNames() ([]string, error) {
+// Return the raw 'packageManager' field from the package.json (e.g. "yarn@4.1.0"), empty if not defined
+func (n *NodeAnalyzer) GetPackageManager() (string, error) {
+	info, err := n.toPkgJson()
+	if err != nil {
+		return "", err
+	}
+
+	return info.PackageManager, nil
+}
+
+// Return the test runner used by the project (jest | vitest | mocha | node), empty if not detected
+func (n *NodeAnalyzer) GetTestRunner() (string, error) {
+	info, err := n.toPkgJson()
+	if err != nil {
+		return "", err
+	}
+
+	for _, runner := range []string{"vitest", "jest", "mocha"} {
+		if _, ok := info.Dependencies[runner]; ok {
+			return runner, nil
+		}
+
+		if _, ok := info.DevDependencies[runner]; ok {
+			return runner, nil
+		}
+	}
+
+	if strings.Contains(info.Scripts["test"], "node --test") {
+		return "node", nil
+	}
+
+	return "", nil
+}
+
+// Return the end-to-end test runner used by the project (playwright | cypress), empty if not detected
+func (n *NodeAnalyzer) GetBrowserTestRunner() (string, error) {
+	info, err := n.toPkgJson()
+	if err != nil {
+		return "", err
+	}
+
+	for _, runner := range []struct {
+		name       string
+		dependency string
+	}{
+		{name: "playwright", dependency: "@playwright/test"},
+		{name: "cypress", dependency: "cypress"},
+	} {
+		if _, ok := info.Dependencies[runner.dependency]; ok {
+			return runner.name, nil
+		}
+
+		if _, ok := info.DevDependencies[runner.dependency]; ok {
+			return runner.name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Return the known native packages in the dependencies of the project
+func (n *NodeAnalyzer) GetNativeDependencies() ([]string, error) {
 	info, err := n.toPkgJson()
 	if err != nil {
 		return nil, err
 	}
 
-	return maps.Keys(info.Scripts), nil
+	nativeDeps := []string{}
+	for _, pkg := range knownNativePackages {
+		_, isDep := info.Dependencies[pkg]
+		_, isDevDep := info.DevDependencies[pkg]
+		if isDep || isDevDep {
+			nativeDeps = append(nativeDeps, pkg)
+		}
+	}
+
+	return nativeDeps, nil
+}
+
+// Define if the project builds native addons, a 'binding.gyp' file is present or a known native package is a dependency
+func (n *NodeAnalyzer) IsNativeAddon() (bool, error) {
+	nativeDeps, err := n.GetNativeDependencies()
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(n.Matches, "native") || len(nativeDeps) > 0, nil
 }
 
-func (n *NodeAnalyzer) GetWorkspaces() ([]string, error) {
+// Return the static site framework used by the project (astro | next | vite | cra), empty if not detected
+func (n *NodeAnalyzer) GetStaticSiteFramework() (string, error) {
+	info, err := n.toPkgJson()
+	if err != nil {
+		return "", err
+	}
+
+	// Astro depends on vite, it has to be checked first
+	for _, framework := range []struct {
+		name       string
+		dependency string
+	}{
+		{name: "astro", dependency: "astro"},
+		{name: "next", dependency: "next"},
+		{name: "cra", dependency: "react-scripts"},
+		{name: "vite", dependency: "vite"},
+	} {
+		if _, ok := info.Dependencies[framework.dependency]; ok {
+			return framework.name, nil
+		}
+
+		if _, ok := info.DevDependencies[framework.dependency]; ok {
+			return framework.name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Return the license from the package.json
+func (n *NodeAnalyzer) GetLicense() (string, error) {
+	info, err := n.toPkgJson()
+	if err != nil {
+		return "", err
+	}
+
+	return string(info.License), nil
+}
+
+// Return the repository url from the package.json as an https url when possible
+func (n *NodeAnalyzer) GetRepositoryUrl() (string, error) {
+	info, err := n.toPkgJson()
+	if err != nil {
+		return "", err
+	}
+
+	if info.Repository == nil || info.Repository.URL == "" {
+		return "", nil
+	}
+
+	return normalizeRepositoryUrl(info.Repository.URL), nil
+}
+
+// normalizeRepositoryUrl turn the npm repository forms (git+https, git@, shorthands) into an https url
+func normalizeRepositoryUrl(url string) string {
+	url = strings.TrimSuffix(strings.TrimPrefix(url, "git+"), ".git")
+
+	for prefix, host := range map[string]string{
+		"github:":    "github.com",
+		"gitlab:":    "gitlab.com",
+		"bitbucket:": "bitbucket.org",
+	} {
+		if strings.HasPrefix(url, prefix) {
+			return "https://" + host + "/" + strings.TrimPrefix(url, prefix)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(url, "git@"):
+		return "https://" + strings.Replace(strings.TrimPrefix(url, "git@"), ":", "/", 1)
+	case strings.HasPrefix(url, "ssh://git@"):
+		return "https://" + strings.TrimPrefix(url, "ssh://git@")
+	case strings.HasPrefix(url, "git://"):
+		return "https://" + strings.TrimPrefix(url, "git://")
+	case !strings.Contains(url, ":") && strings.Count(url, "/") == 1:
+		// npm shorthand for github repositories
+		return "https://github.com/" + url
+	}
+
+	return url
+}
+
+func (n *NodeAnalyzer) GetScriptNames() ([]string, error) {
 	info, err := n.toPkgJson()
 	if err != nil {
 		return nil, err
 	}
 
-	return info.Workspaces, nil
+	return maps.Keys(info.Scripts), nil
+}
+
+// Return the workspace globs from the package.json or the pnpm-workspace.yaml
+func (n *NodeAnalyzer) GetWorkspaces() []string {
+	return n.WorkspaceGlobs
+}
+
+// Return the packages matching the workspace globs with their dependencies on the other packages
+func (n *NodeAnalyzer) GetWorkspacePackages() []WorkspacePackage {
+	return n.WorkspacePackages
+}
+
+// Return the name of the workspace packages affected by the changes and the packages depending on them, a change of a root dependency or shared config file affects all of them
+func (n *NodeAnalyzer) GetAffectedWorkspaces(
+	ctx context.Context,
+	// The files changed relative to the root of the project
+	// +optional
+	changedFiles []string,
+	// A git ref to compare with HEAD to find the changed files, the source has to contain the '.git' folder with the history
+	// +optional
+	baseRef string,
+) ([]string, error) {
+	if baseRef != "" {
+		gitFiles, err := gitChangedFiles(ctx, n.Dir, n.InternalImage, baseRef)
+		if err != nil {
+			return nil, err
+		}
+
+		changedFiles = append(changedFiles, gitFiles...)
+	}
+
+	return affectedWorkspaces(n.WorkspacePackages, changedFiles), nil
 }
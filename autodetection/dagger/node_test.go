@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestDefaultNodeTestPatterns(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "basic.test.ts", want: true},
+		{name: "suite.spec.js", want: true},
+		{name: "button.test.tsx", want: true},
+		{name: "button.spec.jsx", want: true},
+		{name: "loader.test.mjs", want: true},
+		{name: "config.spec.cts", want: true},
+		{name: "suite.test.ts.snap", want: false},
+		{name: "basic.test.ts.map", want: false},
+		{name: "basic.test.d.ts", want: false},
+		{name: "index.ts", want: false},
+		{name: "testing.ts", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := false
+			for _, pattern := range defaultNodePatterns["test"].Patterns {
+				if regexp.MustCompile(pattern).MatchString(tt.name) {
+					got = true
+					break
+				}
+			}
+
+			if got != tt.want {
+				t.Errorf("the test patterns match %q: %t, want %t", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"main/internal/dagger"
 	"slices"
+	"strings"
 )
 
 var defaultOciPatterns = map[string]PatternMatch{
@@ -16,7 +17,8 @@ var defaultOciPatterns = map[string]PatternMatch{
 }
 
 type OciAnalyzer struct {
-	Matches []string
+	Matches     []string
+	Dockerfiles []string
 }
 
 func newOciAnalyzer(ctx context.Context, dir *dagger.Directory, patternExclusions []string, internalImage string) (*OciAnalyzer, error) {
@@ -34,11 +36,27 @@ func newOciAnalyzer(ctx context.Context, dir *dagger.Directory, patternExclusion
 		return nil, err
 	}
 
+	dockerfiles := anlzr.getMatchedFiles("oci")
+	// The closest files to the root come first
+	slices.SortFunc(dockerfiles, func(a, b string) int {
+		if depth := strings.Count(a, "/") - strings.Count(b, "/"); depth != 0 {
+			return depth
+		}
+
+		return strings.Compare(a, b)
+	})
+
 	return &OciAnalyzer{
-		Matches: anlzr.getMatch(),
+		Matches:     anlzr.getMatch(),
+		Dockerfiles: dockerfiles,
 	}, nil
 }
 
 func (n *OciAnalyzer) IsOci() bool {
 	return slices.Contains(n.Matches, "oci")
 }
+
+// Return the path of the Dockerfile / Containerfile found, the closest to the root first
+func (n *OciAnalyzer) GetDockerfiles() []string {
+	return n.Dockerfiles
+}
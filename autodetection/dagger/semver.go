@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var hyphenRangeRe = regexp.MustCompile(`^\s*(\S+)\s+-\s+(\S+)\s*$`)
+
+type semver struct {
+	Major int
+	Minor int
+	Patch int
+	Pre   string
+}
+
+// parseVersion parse a full version like "v20.9.0" or "20.9.0-rc.1"
+func parseVersion(raw string) (semver, error) {
+	major, minor, patch, pre, err := parsePartialVersion(raw)
+	if err != nil {
+		return semver{}, err
+	}
+
+	if major < 0 || minor < 0 || patch < 0 {
+		return semver{}, fmt.Errorf("'%s' is not a full version", raw)
+	}
+
+	return semver{Major: major, Minor: minor, Patch: patch, Pre: pre}, nil
+}
+
+// parsePartialVersion parse a version where missing or wildcard parts (x, X, *) are returned as -1
+func parsePartialVersion(raw string) (int, int, int, string, error) {
+	raw = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(raw), "="), "v")
+	raw, _, _ = strings.Cut(raw, "+")
+	raw, pre, _ := strings.Cut(raw, "-")
+
+	parts := []int{-1, -1, -1}
+	if raw == "" {
+		return -1, -1, -1, "", nil
+	}
+
+	for i, part := range strings.Split(raw, ".") {
+		if i > 2 {
+			return 0, 0, 0, "", fmt.Errorf("not able to parse the version: '%s'", raw)
+		}
+
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, 0, 0, "", fmt.Errorf("not able to parse the version: '%s'", raw)
+		}
+		parts[i] = value
+	}
+
+	return parts[0], parts[1], parts[2], pre, nil
+}
+
+func (v semver) compare(o semver) int {
+	if v.Major != o.Major {
+		return v.Major - o.Major
+	}
+
+	if v.Minor != o.Minor {
+		return v.Minor - o.Minor
+	}
+
+	if v.Patch != o.Patch {
+		return v.Patch - o.Patch
+	}
+
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	default:
+		return strings.Compare(v.Pre, o.Pre)
+	}
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+type comparator struct {
+	op      string
+	version semver
+}
+
+func (c comparator) match(v semver) bool {
+	cmp := v.compare(c.version)
+
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp == 0
+	}
+}
+
+// semverRange is a union (||) of comparator sets which all have to match
+type semverRange [][]comparator
+
+func (r semverRange) match(v semver) bool {
+	for _, set := range r {
+		matched := true
+		for _, c := range set {
+			if !c.match(v) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRange parse a node semver range like ">=18", "^20", "20.x" or ">=18.0.0 <21 || 22"
+func parseRange(raw string) (semverRange, error) {
+	var r semverRange
+
+	for _, rawSet := range strings.Split(raw, "||") {
+		var set []comparator
+
+		if parts := hyphenRangeRe.FindStringSubmatch(rawSet); parts != nil {
+			lower, err := expandComparator(">=", parts[1])
+			if err != nil {
+				return nil, err
+			}
+
+			upper, err := expandComparator("<=", parts[2])
+			if err != nil {
+				return nil, err
+			}
+
+			r = append(r, append(lower, upper...))
+			continue
+		}
+
+		for _, token := range splitComparators(rawSet) {
+			rawVersion := strings.TrimLeft(token, "<>=^~")
+			op := strings.TrimSuffix(token, rawVersion)
+
+			comparators, err := expandComparator(op, rawVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			set = append(set, comparators...)
+		}
+
+		r = append(r, set)
+	}
+
+	return r, nil
+}
+
+// splitComparators split a comparator set on spaces while keeping an operator attached to its version (">= 18" -> ">=18")
+func splitComparators(raw string) []string {
+	var tokens []string
+
+	for _, field := range strings.Fields(raw) {
+		if len(tokens) > 0 && strings.Trim(tokens[len(tokens)-1], "<>=^~") == "" {
+			tokens[len(tokens)-1] += field
+			continue
+		}
+
+		tokens = append(tokens, field)
+	}
+
+	return tokens
+}
+
+// expandComparator turn an operator with a partial version into primitive comparators
+func expandComparator(op string, rawVersion string) ([]comparator, error) {
+	major, minor, patch, pre, err := parsePartialVersion(rawVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	lower := semver{Major: max(major, 0), Minor: max(minor, 0), Patch: max(patch, 0), Pre: pre}
+
+	// The first version after the partial one, e.g. 1.2 -> 1.3.0
+	var next semver
+	switch {
+	case major < 0:
+		next = semver{}
+	case minor < 0:
+		next = semver{Major: major + 1}
+	case patch < 0:
+		next = semver{Major: major, Minor: minor + 1}
+	}
+
+	isFull := patch >= 0
+
+	switch op {
+	case "", "=":
+		if major < 0 {
+			return nil, nil
+		}
+
+		if isFull {
+			return []comparator{{op: "=", version: lower}}, nil
+		}
+
+		return []comparator{{op: ">=", version: lower}, {op: "<", version: next}}, nil
+	case "^":
+		if major < 0 {
+			return nil, nil
+		}
+
+		var upper semver
+		switch {
+		case major > 0 || minor < 0:
+			upper = semver{Major: lower.Major + 1}
+		case minor > 0 || patch < 0:
+			upper = semver{Minor: lower.Minor + 1}
+		default:
+			upper = semver{Patch: lower.Patch + 1}
+		}
+
+		return []comparator{{op: ">=", version: lower}, {op: "<", version: upper}}, nil
+	case "~":
+		if major < 0 {
+			return nil, nil
+		}
+
+		upper := semver{Major: lower.Major, Minor: lower.Minor + 1}
+		if minor < 0 {
+			upper = semver{Major: lower.Major + 1}
+		}
+
+		return []comparator{{op: ">=", version: lower}, {op: "<", version: upper}}, nil
+	case ">":
+		if major < 0 {
+			return []comparator{{op: "<", version: semver{}}}, nil
+		}
+
+		if isFull {
+			return []comparator{{op: ">", version: lower}}, nil
+		}
+
+		return []comparator{{op: ">=", version: next}}, nil
+	case ">=":
+		return []comparator{{op: ">=", version: lower}}, nil
+	case "<":
+		return []comparator{{op: "<", version: lower}}, nil
+	case "<=":
+		if major < 0 {
+			return nil, nil
+		}
+
+		if isFull {
+			return []comparator{{op: "<=", version: lower}}, nil
+		}
+
+		return []comparator{{op: "<", version: next}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported semver operator '%s'", op)
+	}
+}
+
+// nodeRelease is an entry of the node distribution index (https://nodejs.org/dist/index.json)
+type nodeRelease struct {
+	Version string `json:"version"`
+	Lts     any    `json:"lts"`
+}
+
+func (r nodeRelease) ltsName() string {
+	name, _ := r.Lts.(string)
+
+	return name
+}
+
+// resolveNodeVersion return the newest version of the index matching the spec, an exact version is returned as is
+func resolveNodeVersion(spec string, index string) (string, error) {
+	spec = strings.TrimSpace(spec)
+
+	if v, err := parseVersion(spec); err == nil && !strings.ContainsAny(spec, "<>^~ |") {
+		return v.String(), nil
+	}
+
+	var releases []nodeRelease
+	err := json.Unmarshal([]byte(index), &releases)
+	if err != nil {
+		return "", fmt.Errorf("not able to read the node version index: %w", err)
+	}
+
+	var versions []semver
+	ltsVersions := map[semver]string{}
+	for _, release := range releases {
+		v, err := parseVersion(release.Version)
+		if err != nil || v.Pre != "" {
+			continue
+		}
+
+		versions = append(versions, v)
+		ltsVersions[v] = strings.ToLower(release.ltsName())
+	}
+
+	slices.SortFunc(versions, func(a, b semver) int {
+		return b.compare(a)
+	})
+
+	var match func(v semver) bool
+
+	lowerSpec := strings.ToLower(spec)
+	switch {
+	case lowerSpec == "lts/*":
+		match = func(v semver) bool { return ltsVersions[v] != "" }
+	case strings.HasPrefix(lowerSpec, "lts/"):
+		codename := strings.TrimPrefix(lowerSpec, "lts/")
+		match = func(v semver) bool { return ltsVersions[v] == codename }
+	case slices.Contains([]string{"node", "latest", "current", "stable"}, lowerSpec):
+		match = func(v semver) bool { return true }
+	default:
+		r, err := parseRange(spec)
+		if err != nil {
+			return "", fmt.Errorf("not able to parse the node engine version: '%s': %w", spec, err)
+		}
+		match = r.match
+	}
+
+	for _, v := range versions {
+		if match(v) {
+			return v.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no node version found matching '%s'", spec)
+}
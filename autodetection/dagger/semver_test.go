@@ -0,0 +1,305 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    semver
+		wantErr bool
+	}{
+		{raw: "20.9.0", want: semver{Major: 20, Minor: 9, Patch: 0}},
+		{raw: "v18.19.1", want: semver{Major: 18, Minor: 19, Patch: 1}},
+		{raw: "=22.1.0", want: semver{Major: 22, Minor: 1, Patch: 0}},
+		{raw: "21.0.0-rc.1", want: semver{Major: 21, Pre: "rc.1"}},
+		{raw: "20.9.0+build.5", want: semver{Major: 20, Minor: 9}},
+		{raw: "20.9", wantErr: true},
+		{raw: "20.x", wantErr: true},
+		{raw: "20.9.0.1", wantErr: true},
+		{raw: "twenty", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseVersion(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseVersion(%q) = %v, want an error", tt.raw, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseVersion(%q) returned an error: %v", tt.raw, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("parseVersion(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{a: "20.9.0", b: "20.9.0", want: 0},
+		{a: "20.10.0", b: "20.9.0", want: 1},
+		{a: "18.20.0", b: "20.0.0", want: -1},
+		{a: "20.0.1", b: "20.0.0", want: 1},
+		{a: "20.0.0", b: "20.0.0-rc.1", want: 1},
+		{a: "20.0.0-rc.1", b: "20.0.0", want: -1},
+		{a: "20.0.0-rc.1", b: "20.0.0-rc.2", want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			a, err := parseVersion(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := parseVersion(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := a.compare(b)
+			switch {
+			case tt.want == 0 && got != 0, tt.want > 0 && got <= 0, tt.want < 0 && got >= 0:
+				t.Errorf("%s.compare(%s) = %d, want the sign of %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		matching []string
+		other    []string
+	}{
+		{
+			name:     "exact",
+			spec:     "20.9.0",
+			matching: []string{"20.9.0"},
+			other:    []string{"20.9.1", "20.8.0"},
+		},
+		{
+			name:     "partial",
+			spec:     "20",
+			matching: []string{"20.0.0", "20.19.5"},
+			other:    []string{"19.9.9", "21.0.0"},
+		},
+		{
+			name:     "wildcard",
+			spec:     "20.x",
+			matching: []string{"20.0.0", "20.11.1"},
+			other:    []string{"21.0.0"},
+		},
+		{
+			name:     "star",
+			spec:     "*",
+			matching: []string{"0.0.1", "24.11.0"},
+		},
+		{
+			name:     "caret major",
+			spec:     "^20.9.0",
+			matching: []string{"20.9.0", "20.19.5"},
+			other:    []string{"20.8.9", "21.0.0"},
+		},
+		{
+			name:     "caret partial",
+			spec:     "^18",
+			matching: []string{"18.0.0", "18.20.4"},
+			other:    []string{"17.9.0", "19.0.0"},
+		},
+		{
+			name:     "caret zero major",
+			spec:     "^0.2.3",
+			matching: []string{"0.2.3", "0.2.9"},
+			other:    []string{"0.3.0", "0.2.2"},
+		},
+		{
+			name:     "caret zero minor",
+			spec:     "^0.0.3",
+			matching: []string{"0.0.3"},
+			other:    []string{"0.0.4", "0.1.0"},
+		},
+		{
+			name:     "tilde full",
+			spec:     "~20.9.1",
+			matching: []string{"20.9.1", "20.9.9"},
+			other:    []string{"20.9.0", "20.10.0"},
+		},
+		{
+			name:     "tilde major",
+			spec:     "~20",
+			matching: []string{"20.0.0", "20.19.5"},
+			other:    []string{"21.0.0"},
+		},
+		{
+			name:     "comparators",
+			spec:     ">=18.0.0 <21",
+			matching: []string{"18.0.0", "20.19.5"},
+			other:    []string{"17.9.9", "21.0.0"},
+		},
+		{
+			name:     "spaced operator",
+			spec:     ">= 18 < 20",
+			matching: []string{"18.0.0", "19.9.0"},
+			other:    []string{"20.0.0"},
+		},
+		{
+			name:     "greater than partial",
+			spec:     ">20",
+			matching: []string{"21.0.0"},
+			other:    []string{"20.19.5"},
+		},
+		{
+			name:     "less or equal partial",
+			spec:     "<=20",
+			matching: []string{"20.19.5"},
+			other:    []string{"21.0.0"},
+		},
+		{
+			name:     "hyphen",
+			spec:     "18.1.0 - 20.2.0",
+			matching: []string{"18.1.0", "20.2.0"},
+			other:    []string{"18.0.9", "20.2.1"},
+		},
+		{
+			name:     "hyphen partial",
+			spec:     "18 - 20.3",
+			matching: []string{"18.0.0", "20.3.9"},
+			other:    []string{"17.9.9", "20.4.0"},
+		},
+		{
+			name:     "union",
+			spec:     "^18 || ^20 || >=22.1",
+			matching: []string{"18.20.4", "20.0.0", "22.1.0", "24.11.0"},
+			other:    []string{"19.9.0", "21.0.0", "22.0.0"},
+		},
+		{
+			name:     "union with hyphen",
+			spec:     "16.0.0 - 16.9.0 || 20",
+			matching: []string{"16.5.0", "20.1.0"},
+			other:    []string{"16.10.0", "18.0.0"},
+		},
+		{
+			name:     "prerelease lower bound",
+			spec:     ">=21.0.0-rc.1",
+			matching: []string{"21.0.0-rc.1", "21.0.0-rc.2", "21.0.0"},
+			other:    []string{"21.0.0-beta.1", "20.19.5"},
+		},
+		{
+			name:     "prerelease below an upper bound",
+			spec:     "<21.0.0",
+			matching: []string{"20.19.5", "21.0.0-rc.1"},
+			other:    []string{"21.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRange(tt.spec)
+			if err != nil {
+				t.Fatalf("parseRange(%q) returned an error: %v", tt.spec, err)
+			}
+
+			for _, raw := range tt.matching {
+				if !r.match(mustParseVersion(t, raw)) {
+					t.Errorf("%q doesn't match %s, want a match", tt.spec, raw)
+				}
+			}
+
+			for _, raw := range tt.other {
+				if r.match(mustParseVersion(t, raw)) {
+					t.Errorf("%q matches %s, want no match", tt.spec, raw)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	for _, spec := range []string{">=twenty", "^1.2.3.4", "1.2.3 - 2.y"} {
+		t.Run(spec, func(t *testing.T) {
+			_, err := parseRange(spec)
+			if err == nil {
+				t.Errorf("parseRange(%q) didn't return an error", spec)
+			}
+		})
+	}
+}
+
+func TestResolveNodeVersion(t *testing.T) {
+	index := `[
+		{"version": "v23.0.0-rc.1", "lts": false},
+		{"version": "v22.11.0", "lts": "Jod"},
+		{"version": "v22.1.0", "lts": false},
+		{"version": "v21.7.3", "lts": false},
+		{"version": "v20.18.0", "lts": "Iron"},
+		{"version": "v20.9.0", "lts": "Iron"},
+		{"version": "v18.20.4", "lts": "Hydrogen"}
+	]`
+
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "20.9.0", want: "20.9.0"},
+		{spec: "v20.5.1", want: "20.5.1"},
+		{spec: "20", want: "20.18.0"},
+		{spec: "^20.9.0", want: "20.18.0"},
+		{spec: "~20.9.0", want: "20.9.0"},
+		{spec: ">=18", want: "22.11.0"},
+		{spec: ">=18 <22", want: "21.7.3"},
+		{spec: "18 - 20.10", want: "20.9.0"},
+		{spec: "^18 || ^20", want: "20.18.0"},
+		{spec: ">=22.0.0-rc.1", want: "22.11.0"},
+		{spec: "lts/*", want: "22.11.0"},
+		{spec: "lts/iron", want: "20.18.0"},
+		{spec: "node", want: "22.11.0"},
+		{spec: "^16", wantErr: true},
+		{spec: ">=abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := resolveNodeVersion(tt.spec, index)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolveNodeVersion(%q) = %s, want an error", tt.spec, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("resolveNodeVersion(%q) returned an error: %v", tt.spec, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("resolveNodeVersion(%q) = %s, want %s", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func mustParseVersion(t *testing.T, raw string) semver {
+	t.Helper()
+
+	v, err := parseVersion(raw)
+	if err != nil {
+		t.Fatalf("parseVersion(%q) returned an error: %v", raw, err)
+	}
+
+	return v
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"main/internal/dagger"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var pnpmWorkspacePackageRe = regexp.MustCompile(`^\s*-\s*["']?([^"'#]+?)["']?\s*(#.*)?$`)
+
+// The root files affecting every packages of a monorepo: manifest, lockfiles, package manager and runtime config, yarn berry folder and shared tooling config
+var rootSharedFileRe = regexp.MustCompile(`^(package\.json|package-lock\.json|npm-shrinkwrap\.json|yarn\.lock|pnpm-lock\.yaml|pnpm-workspace\.yaml|bun\.lockb?|\.npmrc|\.yarnrc(\.yml)?|\.yarn/.+|\.nvmrc|\.node-version|tsconfig[^/]*\.json|\.?(eslint|prettier|babel|jest|vitest|turbo|nx|lerna)[^/]*)$`)
+
+// A package of a monorepo
+type WorkspacePackage struct {
+	// The name of the package
+	Name string
+	// The version of the package
+	Version string
+	// The path of the package relative to the root of the project
+	Path string
+	// Indicate the package is private and not published
+	Private bool
+	// The path of the Dockerfile of the package relative to the root of the project if any
+	Dockerfile string
+	// The workspace packages this package depends on
+	Dependencies []string
+	// The name of the scripts of the package.json of the package
+	Scripts []string
+}
+
+type workspaceGlobs []string
+
+// UnmarshalJSON accept the yarn object form of the workspaces (e.g. {"packages": ["packages/*"]})
+func (w *workspaceGlobs) UnmarshalJSON(data []byte) error {
+	var globs []string
+	if json.Unmarshal(data, &globs) == nil {
+		*w = globs
+		return nil
+	}
+
+	var object struct {
+		Packages []string `json:"packages"`
+	}
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return err
+	}
+
+	*w = object.Packages
+	return nil
+}
+
+// readPnpmWorkspaceGlobs return the 'packages' list of the pnpm-workspace.yaml file
+func readPnpmWorkspaceGlobs() ([]string, error) {
+	content, err := os.ReadFile(analyzeFolder + "/pnpm-workspace.yaml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var globs []string
+	inPackages := false
+	for _, line := range strings.Split(string(content), "\n") {
+		switch {
+		case strings.HasPrefix(line, "packages:"):
+			inPackages = true
+		case inPackages && pnpmWorkspacePackageRe.MatchString(line):
+			globs = append(globs, pnpmWorkspacePackageRe.FindStringSubmatch(line)[1])
+		case inPackages && strings.TrimSpace(line) != "" && !strings.HasPrefix(strings.TrimSpace(line), "#"):
+			inPackages = false
+		}
+	}
+
+	return globs, nil
+}
+
+// globToRegexp convert a workspace glob ("packages/*", "apps/**") to a regexp matching relative paths
+func globToRegexp(glob string) *regexp.Regexp {
+	var expr strings.Builder
+
+	glob = strings.TrimSuffix(strings.TrimPrefix(glob, "./"), "/")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+		case glob[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+
+	return regexp.MustCompile("^" + expr.String() + "$")
+}
+
+// resolveWorkspacePackages find the packages matching the workspace globs with their dependencies on the other packages
+func resolveWorkspacePackages(globs []string) ([]WorkspacePackage, error) {
+	var includes, excludes []*regexp.Regexp
+	for _, glob := range globs {
+		if strings.HasPrefix(glob, "!") {
+			excludes = append(excludes, globToRegexp(strings.TrimPrefix(glob, "!")))
+		} else {
+			includes = append(includes, globToRegexp(glob))
+		}
+	}
+
+	if len(includes) == 0 {
+		return []WorkspacePackage{}, nil
+	}
+
+	type workspacePkgJson struct {
+		Name                 string            `json:"name"`
+		Version              string            `json:"version"`
+		Private              bool              `json:"private"`
+		Dependencies         map[string]string `json:"dependencies"`
+		DevDependencies      map[string]string `json:"devDependencies"`
+		PeerDependencies     map[string]string `json:"peerDependencies"`
+		OptionalDependencies map[string]string `json:"optionalDependencies"`
+		Scripts              map[string]string `json:"scripts"`
+	}
+
+	pkgJsons := map[string]workspacePkgJson{}
+	err := filepath.WalkDir(analyzeFolder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && (d.Name() == "node_modules" || d.Name() == ".git") {
+			return filepath.SkipDir
+		}
+
+		if d.IsDir() || d.Name() != "package.json" || path == analyzeFolder+"/package.json" {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(analyzeFolder, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+
+		matches := func(re *regexp.Regexp) bool { return re.MatchString(relPath) }
+		if !slices.ContainsFunc(includes, matches) || slices.ContainsFunc(excludes, matches) {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		pkgJson := workspacePkgJson{}
+		err = json.Unmarshal(content, &pkgJson)
+		if err != nil {
+			return err
+		}
+
+		pkgJsons[relPath] = pkgJson
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, pkgJson := range pkgJsons {
+		names[pkgJson.Name] = true
+	}
+
+	packages := []WorkspacePackage{}
+	for relPath, pkgJson := range pkgJsons {
+		pkg := WorkspacePackage{
+			Name:         pkgJson.Name,
+			Version:      pkgJson.Version,
+			Path:         relPath,
+			Private:      pkgJson.Private,
+			Dependencies: []string{},
+			Scripts:      slices.Sorted(maps.Keys(pkgJson.Scripts)),
+		}
+
+		if _, err := os.Stat(analyzeFolder + "/" + relPath + "/Dockerfile"); err == nil {
+			pkg.Dockerfile = relPath + "/Dockerfile"
+		}
+
+		for _, deps := range []map[string]string{pkgJson.Dependencies, pkgJson.DevDependencies, pkgJson.PeerDependencies, pkgJson.OptionalDependencies} {
+			for dep := range deps {
+				if names[dep] && !slices.Contains(pkg.Dependencies, dep) {
+					pkg.Dependencies = append(pkg.Dependencies, dep)
+				}
+			}
+		}
+		slices.Sort(pkg.Dependencies)
+
+		packages = append(packages, pkg)
+	}
+
+	slices.SortFunc(packages, func(a, b WorkspacePackage) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return packages, nil
+}
+
+// affectedWorkspaces return the packages containing a changed file and the packages depending on them, a change of a root dependency or shared config file affects every packages while the other files outside of the packages (docs, CI...) affect none
+func affectedWorkspaces(packages []WorkspacePackage, changedFiles []string) []string {
+	affected := map[string]bool{}
+
+	for _, file := range changedFiles {
+		file = filepath.ToSlash(filepath.Clean(strings.TrimPrefix(file, "./")))
+
+		if rootSharedFileRe.MatchString(file) {
+			for _, pkg := range packages {
+				affected[pkg.Name] = true
+			}
+			break
+		}
+
+		for _, pkg := range packages {
+			if strings.HasPrefix(file, pkg.Path+"/") {
+				affected[pkg.Name] = true
+			}
+		}
+	}
+
+	// Propagate to the dependents until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for _, pkg := range packages {
+			if affected[pkg.Name] {
+				continue
+			}
+
+			if slices.ContainsFunc(pkg.Dependencies, func(dep string) bool { return affected[dep] }) {
+				affected[pkg.Name] = true
+				changed = true
+			}
+		}
+	}
+
+	names := []string{}
+	for _, pkg := range packages {
+		if affected[pkg.Name] {
+			names = append(names, pkg.Name)
+		}
+	}
+
+	return names
+}
+
+// gitChangedFiles return the files changed between the base ref and HEAD in the git repository of the directory, git is installed in the alpine internal image
+func gitChangedFiles(ctx context.Context, dir *dagger.Directory, internalImage string, baseRef string) ([]string, error) {
+	output, err := dag.
+		Container().
+		From(internalImage).
+		WithExec([]string{"apk", "add", "--no-cache", "git"}).
+		WithMountedDirectory("/src", dir).
+		WithWorkdir("/src").
+		WithExec([]string{"git", "config", "--global", "--add", "safe.directory", "/src"}).
+		WithExec([]string{"git", "diff", "--name-only", baseRef + "...HEAD"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, file := range strings.Split(output, "\n") {
+		if strings.TrimSpace(file) != "" {
+			files = append(files, strings.TrimSpace(file))
+		}
+	}
+
+	return files, nil
+}
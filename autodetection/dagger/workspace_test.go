@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAffectedWorkspaces(t *testing.T) {
+	packages := []WorkspacePackage{
+		{Name: "@acme/api", Path: "apps/api", Dependencies: []string{"@acme/core"}},
+		{Name: "@acme/core", Path: "packages/core", Dependencies: []string{}},
+		{Name: "@acme/ui", Path: "packages/ui", Dependencies: []string{}},
+		{Name: "@acme/web", Path: "apps/web", Dependencies: []string{"@acme/ui"}},
+	}
+	all := []string{"@acme/api", "@acme/core", "@acme/ui", "@acme/web"}
+
+	tests := []struct {
+		name         string
+		changedFiles []string
+		want         []string
+	}{
+		{
+			name:         "no changes",
+			changedFiles: nil,
+			want:         []string{},
+		},
+		{
+			name:         "leaf package",
+			changedFiles: []string{"apps/web/src/index.ts"},
+			want:         []string{"@acme/web"},
+		},
+		{
+			name:         "dependency propagated to the dependents",
+			changedFiles: []string{"packages/ui/button.tsx"},
+			want:         []string{"@acme/ui", "@acme/web"},
+		},
+		{
+			name:         "several packages",
+			changedFiles: []string{"./packages/core/index.ts", "packages/ui/package.json"},
+			want:         []string{"@acme/api", "@acme/core", "@acme/ui", "@acme/web"},
+		},
+		{
+			name:         "root lockfile",
+			changedFiles: []string{"pnpm-lock.yaml"},
+			want:         all,
+		},
+		{
+			name:         "root manifest",
+			changedFiles: []string{"./package.json"},
+			want:         all,
+		},
+		{
+			name:         "root shared config",
+			changedFiles: []string{"apps/web/src/index.ts", "tsconfig.base.json"},
+			want:         all,
+		},
+		{
+			name:         "yarn berry folder",
+			changedFiles: []string{".yarn/patches/lodash.patch"},
+			want:         all,
+		},
+		{
+			name:         "root docs and CI",
+			changedFiles: []string{"README.md", ".github/workflows/ci.yml", "docs/package.json"},
+			want:         []string{},
+		},
+		{
+			name:         "root docs with a package",
+			changedFiles: []string{"CHANGELOG.md", "packages/core/index.ts"},
+			want:         []string{"@acme/api", "@acme/core"},
+		},
+		{
+			name:         "path prefix of another package",
+			changedFiles: []string{"apps/api-docs/index.md", "apps/api/main.ts"},
+			want:         []string{"@acme/api"},
+		},
+		{
+			name:         "package manifest is not a root file",
+			changedFiles: []string{"packages/ui/tsconfig.json"},
+			want:         []string{"@acme/ui", "@acme/web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := affectedWorkspaces(packages, tt.changedFiles)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("affectedWorkspaces(%v) = %v, want %v", tt.changedFiles, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no packages", func(t *testing.T) {
+		got := affectedWorkspaces(nil, []string{"src/index.ts"})
+		if len(got) != 0 {
+			t.Errorf("affectedWorkspaces() = %v, want no workspace", got)
+		}
+	})
+}
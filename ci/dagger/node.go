@@ -20,15 +20,15 @@ func (c *Ci) Node(ctx context.Context, testDataSrc *dagger.Directory) error {
 				testDataSrc.Directory("myapi"),
 			).
 			Pipeline(
-				ctx,
 				dagger.NodePipelineOpts{
 					DryRun: true,
 					TTL:    "5m",
 					IsOci:  true,
 				},
-			)
+			).
+			Refs(ctx)
 
-		fmt.Println("image: " + refs)
+		fmt.Println("image: " + strings.Join(refs, "\n"))
 
 		return err
 	})
@@ -60,12 +60,12 @@ func (c *Ci) Node(ctx context.Context, testDataSrc *dagger.Directory) error {
 				testDataSrc.Directory("mylib"),
 			).
 			Pipeline(
-				ctx,
 				dagger.NodePipelineOpts{
 					DryRun:        true,
 					PackageDevTag: "beta",
 				},
-			)
+			).
+			Summary(ctx)
 
 		return err
 	})
@@ -87,5 +87,240 @@ func (c *Ci) Node(ctx context.Context, testDataSrc *dagger.Directory) error {
 		return err
 	})
 
+	// Lazy mode pipeline with pnpm and a package build
+	eg.Go(func() error {
+		_, err := dag.
+			Node().
+			WithAutoSetup(
+				"testdata-mypnpmlib",
+				testDataSrc.Directory("mypnpmlib"),
+			).
+			Pipeline(
+				dagger.NodePipelineOpts{
+					DryRun:        true,
+					PackageDevTag: "beta",
+				},
+			).
+			Summary(ctx)
+
+		return err
+	})
+
+	// Lazy mode pipeline with bun and an oci build
+	eg.Go(func() error {
+		refs, err := dag.
+			Node().
+			WithAutoSetup(
+				"testdata-mybunapi",
+				testDataSrc.Directory("mybunapi"),
+			).
+			Pipeline(
+				dagger.NodePipelineOpts{
+					DryRun: true,
+					TTL:    "5m",
+					IsOci:  true,
+				},
+			).
+			Refs(ctx)
+
+		fmt.Println("image: " + strings.Join(refs, "\n"))
+
+		return err
+	})
+
+	// Explicit mode with the test files split in shards
+	eg.Go(func() error {
+		_, err := dag.
+			Node().
+			WithPipelineID("testdata-mylib-shards").
+			WithVersion("20.9.0").
+			WithSource(testDataSrc.Directory("mylib")).
+			WithNpm().
+			Install().
+			ParallelTest(dagger.NodeParallelTestOpts{
+				Shards:    2,
+				TestFiles: []string{"test/basic.test.ts", "test/suite.test.ts"},
+			}).
+			Do(ctx)
+
+		return err
+	})
+
+	// Lazy mode pipeline with a coverage threshold
+	eg.Go(func() error {
+		_, err := dag.
+			Node().
+			WithAutoSetup(
+				"testdata-mylib-coverage",
+				testDataSrc.Directory("mylib"),
+			).
+			Pipeline(
+				dagger.NodePipelineOpts{
+					DryRun:                true,
+					PackageDevTag:         "beta",
+					CoverageLineThreshold: 1,
+				},
+			).
+			Summary(ctx)
+
+		return err
+	})
+
+	// Lazy mode pipeline with an sbom attached to the image
+	eg.Go(func() error {
+		refs, err := dag.
+			Node().
+			WithAutoSetup(
+				"testdata-mybunapi-sbom",
+				testDataSrc.Directory("mybunapi"),
+			).
+			WithSbom(dagger.NodeWithSbomOpts{Format: "spdx"}).
+			Pipeline(
+				dagger.NodePipelineOpts{
+					DryRun: true,
+					TTL:    "5m",
+					IsOci:  true,
+				},
+			).
+			Refs(ctx)
+
+		fmt.Println("image: " + strings.Join(refs, "\n"))
+
+		return err
+	})
+
+	// Lazy mode pipeline with the dependency audit
+	eg.Go(func() error {
+		_, err := dag.
+			Node().
+			WithAutoSetup(
+				"testdata-mypnpmlib-audit",
+				testDataSrc.Directory("mypnpmlib"),
+			).
+			Pipeline(
+				dagger.NodePipelineOpts{
+					DryRun:        true,
+					PackageDevTag: "beta",
+					AuditLevel:    "high",
+				},
+			).
+			Summary(ctx)
+
+		return err
+	})
+
+	// Lazy mode pipeline on the workspaces affected by a change, the api workspace has no lint script
+	eg.Go(func() error {
+		status, err := dag.
+			Node().
+			WithAutoSetup(
+				"testdata-mymonorepo",
+				testDataSrc.Directory("mymonorepo"),
+			).
+			Pipeline(
+				dagger.NodePipelineOpts{
+					DryRun:       true,
+					ChangedFiles: []string{"packages/api/src/index.js", "README.md"},
+				},
+			).
+			Stage("lint").
+			Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		if status != "skipped" {
+			return fmt.Errorf("the lint stage of the affected workspaces without lint script should be skipped, got '%s'", status)
+		}
+
+		return nil
+	})
+
+	// Lazy mode pipeline publishing the packages and building the images per workspace
+	eg.Go(func() error {
+		refs, err := dag.
+			Node().
+			WithAutoSetup(
+				"testdata-mymonorepo-workspaces",
+				testDataSrc.Directory("mymonorepo"),
+			).
+			Pipeline(
+				dagger.NodePipelineOpts{
+					DryRun:        true,
+					TTL:           "5m",
+					PackageDevTag: "beta",
+					PerWorkspace:  true,
+				},
+			).
+			Refs(ctx)
+
+		fmt.Println("workspaces: " + strings.Join(refs, "\n"))
+
+		return err
+	})
+
+	// Lazy mode pipeline with a hook running a script of the package.json
+	eg.Go(func() error {
+		status, err := dag.
+			Node().
+			WithAutoSetup(
+				"testdata-myapi-hooks",
+				testDataSrc.Directory("myapi"),
+			).
+			WithHook("before-test", []string{"clean"}).
+			Pipeline(
+				dagger.NodePipelineOpts{
+					DryRun: true,
+				},
+			).
+			Stage("before-test").
+			Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		if status != "success" {
+			return fmt.Errorf("the before-test hook should succeed, got '%s'", status)
+		}
+
+		return nil
+	})
+
+	// Lazy mode with a package signed with a generated cosign key
+	eg.Go(func() error {
+		keyPair := dag.
+			Container().
+			From("gcr.io/projectsigstore/cosign:v2.5.0").
+			WithEnvVariable("COSIGN_PASSWORD", "testdata").
+			WithWorkdir("/keys").
+			WithExec([]string{"generate-key-pair"}, dagger.ContainerWithExecOpts{UseEntrypoint: true})
+
+		signingKey, err := keyPair.File("/keys/cosign.key").Contents(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = dag.
+			Node().
+			WithAutoSetup(
+				"testdata-mypnpmlib-signed",
+				testDataSrc.Directory("mypnpmlib"),
+			).
+			Install().
+			Build().
+			Publish(dagger.NodePublishOpts{
+				DryRun:             true,
+				DevTag:             "beta",
+				Sign:               true,
+				SigningKey:         dag.SetSecret("testdata-cosign-key", signingKey),
+				SigningKeyPassword: dag.SetSecret("testdata-cosign-password", "testdata"),
+			}).
+			PackageArtifacts().
+			Signature().
+			Contents(ctx)
+
+		return err
+	})
+
 	return eg.Wait()
 }
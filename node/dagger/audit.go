@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"main/internal/dagger"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The severities from the lowest to the highest
+var auditSeverities = []string{"info", "low", "moderate", "high", "critical"}
+
+var ghsaRe = regexp.MustCompile(`GHSA(-[a-z0-9]{4}){3}`)
+
+// The audit commands writing a json report on the standard output, they exit with an error when vulnerabilities are found
+var auditCommands = map[string]string{
+	"npm":  "npm audit --json",
+	"pnpm": "pnpm audit --json",
+	"bun":  "bun audit --json",
+	"yarn": "if yarn --version | grep -q '^1\\.'; then yarn audit --json; else yarn npm audit --all --recursive --json; fi",
+}
+
+// A vulnerability found in the dependencies
+type AuditFinding struct {
+	// The advisory id, the GitHub advisory id when available
+	ID string
+	// The vulnerable package
+	Package string
+	// The severity (info | low | moderate | high | critical)
+	Severity string
+	// The title of the advisory
+	Title string
+	// The url of the advisory
+	URL string
+	// Indicate the advisory is in the allowlist
+	Allowed bool
+}
+
+// The normalized result of the package manager audit
+type AuditReport struct {
+	// The vulnerabilities found in the dependencies
+	Findings []AuditFinding
+}
+
+// Return the number of findings with this severity which are not allowed
+func (r *AuditReport) Count(severity string) int {
+	count := 0
+	for _, finding := range r.Findings {
+		if finding.Severity == severity && !finding.Allowed {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Audit the dependencies with the package manager and fail when findings reach the severity
+func (n *Node) Audit(
+	ctx context.Context,
+	// Fail when a finding has at least this severity (info | low | moderate | high | critical | none)
+	// +optional
+	// +default="high"
+	failOn string,
+	// A file with the accepted advisory ids (one per line, '#' for comments)
+	// +optional
+	allowlist *dagger.File,
+) (*AuditReport, error) {
+	if failOn != "none" && !slices.Contains(auditSeverities, failOn) {
+		return nil, fmt.Errorf("unsupported audit severity '%s' (info | low | moderate | high | critical | none)", failOn)
+	}
+
+	auditCmd, ok := auditCommands[n.PkgMgr]
+	if !ok {
+		auditCmd = auditCommands["npm"]
+	}
+
+	ctr := n.Ctr.WithExec([]string{"sh", "-c", auditCmd}, dagger.ContainerWithExecOpts{
+		Expect: dagger.ReturnTypeAny,
+	})
+
+	exitCode, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := ctr.Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// A missing or failing audit command doesn't write a report, it must not pass the gate as an audit without findings
+	if strings.TrimSpace(output) == "" {
+		stderr, _ := ctr.Stderr(ctx)
+		return nil, fmt.Errorf("the audit didn't write a report (exit code %d):\n%s", exitCode, stderr)
+	}
+
+	report, err := parseAudit(output)
+	if err != nil {
+		return nil, fmt.Errorf("not able to read the audit report (exit code %d): %w", exitCode, err)
+	}
+
+	// The exit code is not zero when vulnerabilities are found, without findings the audit itself failed
+	if exitCode != 0 && len(report.Findings) == 0 {
+		stderr, _ := ctr.Stderr(ctx)
+		return nil, fmt.Errorf("the audit failed with the exit code %d without reporting vulnerabilities:\n%s", exitCode, stderr)
+	}
+
+	// The most severe findings first
+	slices.SortFunc(report.Findings, func(a, b AuditFinding) int {
+		if severity := slices.Index(auditSeverities, b.Severity) - slices.Index(auditSeverities, a.Severity); severity != 0 {
+			return severity
+		}
+
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	if allowlist != nil {
+		content, err := allowlist.Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed := parseAllowlist(content)
+		for idx, finding := range report.Findings {
+			report.Findings[idx].Allowed = slices.Contains(allowed, finding.ID)
+		}
+	}
+
+	if failOn == "none" {
+		return report, nil
+	}
+
+	var blocking []string
+	for _, finding := range report.Findings {
+		if !finding.Allowed && slices.Index(auditSeverities, finding.Severity) >= slices.Index(auditSeverities, failOn) {
+			blocking = append(blocking, fmt.Sprintf("%s (%s, %s): %s", finding.ID, finding.Package, finding.Severity, finding.Title))
+		}
+	}
+
+	if len(blocking) > 0 {
+		return nil, fmt.Errorf("the audit found %d vulnerabilities with a severity of at least '%s':\n%s", len(blocking), failOn, strings.Join(blocking, "\n"))
+	}
+
+	return report, nil
+}
+
+func parseAllowlist(content string) []string {
+	var allowed []string
+
+	for _, line := range strings.Split(content, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line != "" {
+			allowed = append(allowed, line)
+		}
+	}
+
+	return allowed
+}
+
+// parseAudit normalize the json reports of npm (v7+), pnpm and npm v6, yarn classic and berry, and bun
+func parseAudit(output string) (*AuditReport, error) {
+	report := &AuditReport{Findings: []AuditFinding{}}
+
+	var document map[string]json.RawMessage
+	if json.Unmarshal([]byte(output), &document) == nil {
+		switch {
+		case document["error"] != nil:
+			return nil, fmt.Errorf("the audit failed: %s", document["error"])
+		case document["type"] != nil || document["value"] != nil:
+			// yarn with a single line
+			return report, report.addYarnLine([]byte(output))
+		case document["vulnerabilities"] != nil:
+			return report, report.addNpmVulnerabilities(document["vulnerabilities"])
+		case document["advisories"] != nil:
+			return report, report.addNpmAdvisories(document["advisories"])
+		default:
+			return report, report.addBunAdvisories(document)
+		}
+	}
+
+	// yarn writes one json document per line
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		err := report.addYarnLine(scanner.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, scanner.Err()
+}
+
+func (r *AuditReport) add(id string, pkg string, severity string, title string, url string) {
+	if ghsa := ghsaRe.FindString(url); ghsa != "" {
+		id = ghsa
+	}
+
+	for _, finding := range r.Findings {
+		if finding.ID == id && finding.Package == pkg {
+			return
+		}
+	}
+
+	r.Findings = append(r.Findings, AuditFinding{
+		ID:       id,
+		Package:  pkg,
+		Severity: strings.ToLower(severity),
+		Title:    title,
+		URL:      url,
+	})
+}
+
+// addNpmVulnerabilities parse the npm v7+ format where the advisories are in the 'via' field of each vulnerable package
+func (r *AuditReport) addNpmVulnerabilities(data json.RawMessage) error {
+	var vulnerabilities map[string]struct {
+		Via []json.RawMessage `json:"via"`
+	}
+	err := json.Unmarshal(data, &vulnerabilities)
+	if err != nil {
+		return err
+	}
+
+	for pkg, vulnerability := range vulnerabilities {
+		for _, via := range vulnerability.Via {
+			var advisory struct {
+				Source   int    `json:"source"`
+				Name     string `json:"name"`
+				Title    string `json:"title"`
+				URL      string `json:"url"`
+				Severity string `json:"severity"`
+			}
+
+			// A string refers to another vulnerable package which has its own entry
+			if json.Unmarshal(via, &advisory) != nil {
+				continue
+			}
+
+			if advisory.Name == "" {
+				advisory.Name = pkg
+			}
+
+			r.add(strconv.Itoa(advisory.Source), advisory.Name, advisory.Severity, advisory.Title, advisory.URL)
+		}
+	}
+
+	return nil
+}
+
+// addNpmAdvisories parse the npm v6 format also used by pnpm
+func (r *AuditReport) addNpmAdvisories(data json.RawMessage) error {
+	var advisories map[string]struct {
+		ID               int    `json:"id"`
+		GithubAdvisoryID string `json:"github_advisory_id"`
+		ModuleName       string `json:"module_name"`
+		Title            string `json:"title"`
+		URL              string `json:"url"`
+		Severity         string `json:"severity"`
+	}
+	err := json.Unmarshal(data, &advisories)
+	if err != nil {
+		return err
+	}
+
+	for _, advisory := range advisories {
+		id := strconv.Itoa(advisory.ID)
+		if advisory.GithubAdvisoryID != "" {
+			id = advisory.GithubAdvisoryID
+		}
+
+		r.add(id, advisory.ModuleName, advisory.Severity, advisory.Title, advisory.URL)
+	}
+
+	return nil
+}
+
+// addBunAdvisories parse the bun format where the advisories are grouped by package
+func (r *AuditReport) addBunAdvisories(document map[string]json.RawMessage) error {
+	for pkg, data := range document {
+		var advisories []struct {
+			ID       int    `json:"id"`
+			Title    string `json:"title"`
+			URL      string `json:"url"`
+			Severity string `json:"severity"`
+		}
+		err := json.Unmarshal(data, &advisories)
+		if err != nil {
+			return fmt.Errorf("not able to parse the audit report: %w", err)
+		}
+
+		for _, advisory := range advisories {
+			r.add(strconv.Itoa(advisory.ID), pkg, advisory.Severity, advisory.Title, advisory.URL)
+		}
+	}
+
+	return nil
+}
+
+// addYarnLine parse a line of the yarn classic ('auditAdvisory' events) or yarn berry format
+func (r *AuditReport) addYarnLine(line []byte) error {
+	var event struct {
+		Type     string          `json:"type"`
+		Data     json.RawMessage `json:"data"`
+		Value    string          `json:"value"`
+		Children struct {
+			ID       json.Number `json:"ID"`
+			Issue    string      `json:"Issue"`
+			URL      string      `json:"URL"`
+			Severity string      `json:"Severity"`
+		} `json:"children"`
+	}
+	err := json.Unmarshal(line, &event)
+	if err != nil {
+		return fmt.Errorf("not able to parse the audit report: %w", err)
+	}
+
+	switch {
+	case event.Type == "error":
+		return fmt.Errorf("the audit failed: %s", event.Data)
+	case event.Type == "auditAdvisory":
+		var data struct {
+			Advisory struct {
+				ID         int    `json:"id"`
+				ModuleName string `json:"module_name"`
+				Title      string `json:"title"`
+				URL        string `json:"url"`
+				Severity   string `json:"severity"`
+			} `json:"advisory"`
+		}
+		err := json.Unmarshal(event.Data, &data)
+		if err != nil {
+			return fmt.Errorf("not able to parse the audit advisory: %w", err)
+		}
+
+		advisory := data.Advisory
+		r.add(strconv.Itoa(advisory.ID), advisory.ModuleName, advisory.Severity, advisory.Title, advisory.URL)
+	case event.Value != "":
+		r.add(event.Children.ID.String(), event.Value, event.Children.Severity, event.Children.Issue, event.Children.URL)
+	}
+
+	return nil
+}
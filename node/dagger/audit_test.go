@@ -0,0 +1,221 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseAudit(t *testing.T) {
+	lodash := AuditFinding{
+		ID:       "GHSA-jf85-cpcp-j695",
+		Package:  "lodash",
+		Severity: "critical",
+		Title:    "Prototype Pollution in lodash",
+		URL:      "https://github.com/advisories/GHSA-jf85-cpcp-j695",
+	}
+
+	tests := []struct {
+		name    string
+		output  string
+		want    []AuditFinding
+		wantErr bool
+	}{
+		{
+			name: "npm",
+			output: `{
+  "auditReportVersion": 2,
+  "vulnerabilities": {
+    "lodash": {
+      "name": "lodash",
+      "severity": "critical",
+      "isDirect": true,
+      "via": [
+        {
+          "source": 1096305,
+          "name": "lodash",
+          "dependency": "lodash",
+          "title": "Prototype Pollution in lodash",
+          "url": "https://github.com/advisories/GHSA-jf85-cpcp-j695",
+          "severity": "critical",
+          "range": "<4.17.12"
+        }
+      ],
+      "effects": ["async-utils"],
+      "range": "<4.17.12",
+      "nodes": ["node_modules/lodash"],
+      "fixAvailable": true
+    },
+    "async-utils": {
+      "name": "async-utils",
+      "severity": "critical",
+      "isDirect": false,
+      "via": ["lodash"],
+      "effects": [],
+      "range": "*",
+      "nodes": ["node_modules/async-utils"],
+      "fixAvailable": false
+    },
+    "semver": {
+      "name": "semver",
+      "severity": "moderate",
+      "isDirect": false,
+      "via": [
+        {
+          "source": 1101088,
+          "name": "semver",
+          "dependency": "semver",
+          "title": "semver vulnerable to Regular Expression Denial of Service",
+          "url": "https://github.com/advisories/GHSA-c2qf-rxjj-qqgw",
+          "severity": "moderate",
+          "range": ">=7.0.0 <7.5.2"
+        }
+      ],
+      "effects": [],
+      "range": "7.0.0 - 7.5.1",
+      "nodes": ["node_modules/semver"],
+      "fixAvailable": true
+    }
+  },
+  "metadata": {"vulnerabilities": {"info": 0, "low": 0, "moderate": 1, "high": 0, "critical": 2, "total": 3}}
+}`,
+			want: []AuditFinding{
+				{
+					ID:       "GHSA-c2qf-rxjj-qqgw",
+					Package:  "semver",
+					Severity: "moderate",
+					Title:    "semver vulnerable to Regular Expression Denial of Service",
+					URL:      "https://github.com/advisories/GHSA-c2qf-rxjj-qqgw",
+				},
+				lodash,
+			},
+		},
+		{
+			name:   "npm without vulnerabilities",
+			output: `{"auditReportVersion": 2, "vulnerabilities": {}, "metadata": {"vulnerabilities": {"total": 0}}}`,
+			want:   []AuditFinding{},
+		},
+		{
+			name:    "npm error",
+			output:  `{"error": {"code": "ENOLOCK", "summary": "This command requires an existing lockfile."}}`,
+			wantErr: true,
+		},
+		{
+			name: "pnpm",
+			output: `{
+  "actions": [],
+  "advisories": {
+    "1096305": {
+      "id": 1096305,
+      "github_advisory_id": "GHSA-jf85-cpcp-j695",
+      "module_name": "lodash",
+      "severity": "critical",
+      "title": "Prototype Pollution in lodash",
+      "url": "https://github.com/advisories/GHSA-jf85-cpcp-j695",
+      "findings": [{"version": "4.17.11", "paths": [".>lodash"]}]
+    }
+  },
+  "muted": [],
+  "metadata": {"vulnerabilities": {"critical": 1}}
+}`,
+			want: []AuditFinding{lodash},
+		},
+		{
+			name: "yarn classic",
+			output: `{"type":"auditAdvisory","data":{"resolution":{"id":1096305,"path":"lodash","dev":false},"advisory":{"id":1096305,"module_name":"lodash","severity":"critical","title":"Prototype Pollution in lodash","url":"https://github.com/advisories/GHSA-jf85-cpcp-j695"}}}
+{"type":"auditAdvisory","data":{"resolution":{"id":1096305,"path":"async-utils>lodash","dev":false},"advisory":{"id":1096305,"module_name":"lodash","severity":"critical","title":"Prototype Pollution in lodash","url":"https://github.com/advisories/GHSA-jf85-cpcp-j695"}}}
+{"type":"auditSummary","data":{"vulnerabilities":{"info":0,"low":0,"moderate":0,"high":0,"critical":1},"dependencies":12}}
+`,
+			want: []AuditFinding{lodash},
+		},
+		{
+			name:   "yarn classic without vulnerabilities",
+			output: `{"type":"auditSummary","data":{"vulnerabilities":{"info":0,"low":0,"moderate":0,"high":0,"critical":0},"dependencies":12}}`,
+			want:   []AuditFinding{},
+		},
+		{
+			name:    "yarn classic error",
+			output:  `{"type":"error","data":"An unexpected error occurred: \"Request failed \\\"404 Not Found\\\"\"."}`,
+			wantErr: true,
+		},
+		{
+			name: "yarn berry",
+			output: `{"value":"lodash","children":{"ID":1096305,"Issue":"Prototype Pollution in lodash","URL":"https://github.com/advisories/GHSA-jf85-cpcp-j695","Severity":"critical","Vulnerable Versions":"<4.17.12","Tree Versions":["4.17.11"],"Dependents":["app@workspace:."]}}
+`,
+			want: []AuditFinding{lodash},
+		},
+		{
+			name:   "yarn berry without vulnerabilities",
+			output: `{"type":"info","name":null,"displayName":"YN0001","indent":"","data":"No audit suggestions"}`,
+			want:   []AuditFinding{},
+		},
+		{
+			name: "bun",
+			output: `{
+  "lodash": [
+    {
+      "id": 1096305,
+      "url": "https://github.com/advisories/GHSA-jf85-cpcp-j695",
+      "title": "Prototype Pollution in lodash",
+      "severity": "critical",
+      "vulnerable_versions": "<4.17.12",
+      "cwe": ["CWE-1321"]
+    }
+  ]
+}`,
+			want: []AuditFinding{lodash},
+		},
+		{
+			name:   "bun without vulnerabilities",
+			output: `{}`,
+			want:   []AuditFinding{},
+		},
+		{
+			name:    "not a report",
+			output:  `error: Script not found "audit"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := parseAudit(tt.output)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseAudit() = %+v, want an error", report)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseAudit() returned an error: %v", err)
+			}
+
+			// The npm and bun reports are maps, the findings are compared in a stable order
+			slices.SortFunc(report.Findings, func(a, b AuditFinding) int {
+				return strings.Compare(a.Package, b.Package)
+			})
+			slices.SortFunc(tt.want, func(a, b AuditFinding) int {
+				return strings.Compare(a.Package, b.Package)
+			})
+
+			if !reflect.DeepEqual(report.Findings, tt.want) {
+				t.Errorf("parseAudit() = %+v, want %+v", report.Findings, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAllowlist(t *testing.T) {
+	content := `# accepted until the upgrade of the framework
+GHSA-jf85-cpcp-j695
+  1101088  # no fix available
+
+`
+	want := []string{"GHSA-jf85-cpcp-j695", "1101088"}
+
+	if got := parseAllowlist(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAllowlist() = %v, want %v", got, want)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"main/internal/dagger"
+	"maps"
+	"slices"
 	"strings"
 )
 
@@ -41,33 +43,59 @@ func (n *Node) WithAutoSetup(
 	// +optional
 	// +default="alpine:latest"
 	internalImage string,
+	// A node version index (same format as https://nodejs.org/dist/index.json) used to resolve the engine version range, the bundled one is used by default
+	// +optional
+	nodeVersionIndex *dagger.File,
+	// Make the detected test runner write a junit report in /outputs/reports
+	// +optional
+	testReport bool,
+	// Add a hash of the lockfile, the package manager and the node version in the 'node_modules' cache keys
+	// +optional
+	lockfileCacheKey bool,
+	// The config file in the source declaring hooks, stages to skip, artifacts, registries, env vars, node version and runtime image, the arguments take precedence over it, a file given explicitly has to exist
+	// +optional
+	// +default=".dagger/node.yaml"
+	configFile string,
+	// Override the node version detected from the package.json
+	// +optional
+	nodeVersion string,
 ) (*Node, error) {
-	var err error
+	config, err := loadPipelineConfig(ctx, src, configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = &pipelineConfig{}
+	}
+
+	if systemSetupCmds == nil {
+		systemSetupCmds = config.SystemSetupCmds
+	}
+
 	nodeAutoSetup := &Node{
-		PipelineID:      pipelineId,
-		PkgMgr:          "npm",
-		Platform:        containerPlatform,
-		SystemSetupCmds: systemSetupCmds,
-		Workspaces:      workspaces,
+		PipelineID:                  pipelineId,
+		PkgMgr:                      "npm",
+		Platform:                    containerPlatform,
+		SystemSetupCmds:             systemSetupCmds,
+		Workspaces:                  workspaces,
+		PreHooks:                    config.Hooks["pre"],
+		PostHooks:                   config.Hooks["post"],
+		SkipStages:                  config.Skip,
+		OciRegistries:               config.Registries,
+		FileContainerArtifacts:      config.Artifacts.Files,
+		DirectoryContainerArtifacts: config.Artifacts.Directories,
+		AnalyzerPatternExclusions:   patternExclusions,
+		AnalyzerInternalImage:       internalImage,
+		AnalyzerNodeVersionIndex:    nodeVersionIndex,
 		Ctr: dag.
 			Container(dagger.ContainerOpts{
 				Platform: containerPlatform,
 			}),
 	}
 
-	nodeAnalyzer := dag.
-		Autodetection().
-		Node(
-			src,
-			dagger.AutodetectionNodeOpts{
-				PatternExclusions: append(
-					[]string{"node_modules"},
-					patternExclusions...,
-				),
-				InternalImage: internalImage,
-			},
-		)
-	n.DetectOci, err = dag.
+	nodeAnalyzer := nodeAutoSetup.nodeAnalyzer(src)
+	ociAnalyzer := dag.
 		Autodetection().
 		Oci(
 			src,
@@ -78,23 +106,48 @@ func (n *Node) WithAutoSetup(
 				),
 				InternalImage: internalImage,
 			},
-		).
-		IsOci(ctx)
+		)
+	nodeAutoSetup.DetectOci, err = ociAnalyzer.IsOci(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	engineVersion, err := nodeAnalyzer.GetEngineVersion(ctx)
+	dockerfiles, err := ociAnalyzer.GetDockerfiles(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if len(dockerfiles) > 0 {
+		nodeAutoSetup.Dockerfile = dockerfiles[0]
+	}
 
-	isYarn, err := nodeAnalyzer.IsYarn(ctx)
+	// The argument takes precedence over the config file, the version is only detected without both
+	engineVersion := nodeVersion
+	if engineVersion == "" {
+		engineVersion = string(config.NodeVersion)
+	}
+
+	if engineVersion == "" {
+		engineVersion, err = nodeAnalyzer.GetEngineVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nodeAutoSetup.PkgMgr, err = detectPackageManager(ctx, nodeAnalyzer)
 	if err != nil {
 		return nil, err
 	}
-	if isYarn {
-		nodeAutoSetup.PkgMgr = "yarn"
+
+	yarnNodeLinker, err := nodeAnalyzer.GetYarnNodeLinker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodeAutoSetup.YarnBerry = yarnNodeLinker != ""
+	nodeAutoSetup.YarnPnp = yarnNodeLinker == "pnp"
+
+	pkgMgrSpec, err := nodeAnalyzer.GetPackageManager(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	appVersion, err := nodeAnalyzer.GetVersion(ctx)
@@ -109,11 +162,41 @@ func (n *Node) WithAutoSetup(
 	}
 	nodeAutoSetup.Name = appName
 
+	nodeAutoSetup.License, err = nodeAnalyzer.GetLicense(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeAutoSetup.RepositoryURL, err = nodeAnalyzer.GetRepositoryURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	nodeAutoSetup.DetectTest, err = nodeAnalyzer.IsTest(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	nodeAutoSetup.TestFiles, err = nodeAnalyzer.GetTestFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeAutoSetup.TestRunner, err = nodeAnalyzer.GetTestRunner(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	isNativeAddon, err := nodeAnalyzer.IsNativeAddon(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeAutoSetup.StaticFramework, err = nodeAnalyzer.GetStaticSiteFramework(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	nodeAutoSetup.DetectPackage, err = nodeAnalyzer.IsPackage(ctx)
 	if err != nil {
 		return nil, err
@@ -132,9 +215,85 @@ func (n *Node) WithAutoSetup(
 		nodeAutoSetup.RootWorkspacePaths = append(nodeAutoSetup.RootWorkspacePaths, strings.ReplaceAll(i, "*", ""))
 	}
 
-	return nodeAutoSetup.
-			WithVersion(image, engineVersion, isAlpine).
-			WithSource(src, false).
-			WithPackageManager(n.PkgMgr, false, packageManagerVersion),
-		nil
+	nodeAutoSetup = nodeAutoSetup.
+		WithVersion(image, engineVersion, isAlpine).
+		WithSource(src, false)
+
+	if isNativeAddon {
+		nodeAutoSetup = nodeAutoSetup.WithNativeToolchain()
+	}
+
+	for _, point := range hookPoints {
+		for _, hook := range config.Hooks[point] {
+			nodeAutoSetup.Hooks = append(nodeAutoSetup.Hooks, PipelineHook{Point: point, Command: hook})
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(config.Env)) {
+		nodeAutoSetup.Ctr = nodeAutoSetup.Ctr.WithEnvVariable(name, config.Env[name])
+	}
+
+	if config.RuntimeImage != nil {
+		nodeAutoSetup, err = nodeAutoSetup.WithRuntimeImage(
+			config.RuntimeImage.Image,
+			nil,
+			config.RuntimeImage.User,
+			config.RuntimeImage.Entrypoint,
+			config.RuntimeImage.Cmd,
+			config.RuntimeImage.ExposedPorts,
+			config.RuntimeImage.SetupCmds,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if testReport && nodeAutoSetup.TestRunner != "" {
+		nodeAutoSetup, err = nodeAutoSetup.WithTestReport(nodeAutoSetup.TestRunner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// An explicit package manager version takes precedence over the 'packageManager' field
+	if pkgMgrSpec != "" && packageManagerVersion == "" {
+		nodeAutoSetup = nodeAutoSetup.WithCorepack(pkgMgrSpec, false)
+	} else {
+		nodeAutoSetup = nodeAutoSetup.WithPackageManager(nodeAutoSetup.PkgMgr, false, packageManagerVersion)
+	}
+
+	if lockfileCacheKey {
+		return nodeAutoSetup.WithLockfileCacheKey(ctx)
+	}
+
+	return nodeAutoSetup, nil
+}
+
+// detectPackageManager return the package manager matching the lockfile found by the analyzer, npm is used as fallback
+func detectPackageManager(ctx context.Context, nodeAnalyzer *dagger.AutodetectionNodeAnalyzer) (string, error) {
+	isBun, err := nodeAnalyzer.IsBun(ctx)
+	if err != nil {
+		return "", err
+	}
+	if isBun {
+		return "bun", nil
+	}
+
+	isPnpm, err := nodeAnalyzer.IsPnpm(ctx)
+	if err != nil {
+		return "", err
+	}
+	if isPnpm {
+		return "pnpm", nil
+	}
+
+	isYarn, err := nodeAnalyzer.IsYarn(ctx)
+	if err != nil {
+		return "", err
+	}
+	if isYarn {
+		return "yarn", nil
+	}
+
+	return "npm", nil
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"main/internal/dagger"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The config file read by 'with-auto-setup' when none is given
+const defaultConfigFile = ".dagger/node.yaml"
+
+// The stages of the pipeline which can be skipped from the config file
+var skippableStages = []string{"lint", "test", "build", "publish", "oci"}
+
+var (
+	envVarNameRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	nodeVersionRe = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+)
+
+// pipelineConfig is the schema of the config file, the unknown fields are rejected
+type pipelineConfig struct {
+	// Override the node version detected from the package.json
+	NodeVersion configNodeVersion `json:"nodeVersion"`
+	// The scripts from the package.json to run by hook point, 'pre' and 'post' are the hooks of the pipeline arguments
+	Hooks map[string][][]string `json:"hooks"`
+	// The stages to skip (lint | test | build | publish | oci)
+	Skip []string `json:"skip"`
+	// The extra artifacts to copy from the build container in the image
+	Artifacts struct {
+		Files       []string `json:"files"`
+		Directories []string `json:"directories"`
+	} `json:"artifacts"`
+	// The registries where to push the image
+	Registries []string `json:"registries"`
+	// The environment variables of the build container
+	Env map[string]string `json:"env"`
+	// The system commands to run in the production image
+	SystemSetupCmds [][]string `json:"systemSetupCmds"`
+	// The runtime image of the production image
+	RuntimeImage *struct {
+		Image        string     `json:"image"`
+		User         string     `json:"user"`
+		Entrypoint   []string   `json:"entrypoint"`
+		Cmd          []string   `json:"cmd"`
+		ExposedPorts []int      `json:"exposedPorts"`
+		SetupCmds    [][]string `json:"setupCmds"`
+	} `json:"runtimeImage"`
+}
+
+// configNodeVersion is the node version of the config file, yaml reads an unquoted version like 20 or 20.11 as a number
+type configNodeVersion string
+
+// UnmarshalJSON accept the node version as a string or a number
+func (v *configNodeVersion) UnmarshalJSON(data []byte) error {
+	var version string
+	if json.Unmarshal(data, &version) == nil {
+		*v = configNodeVersion(version)
+		return nil
+	}
+
+	var number json.Number
+	err := json.Unmarshal(data, &number)
+	if err != nil {
+		return fmt.Errorf("'nodeVersion' has to be a string or a number, got %s", data)
+	}
+
+	*v = configNodeVersion(number)
+	return nil
+}
+
+// loadPipelineConfig read the yaml config file from the source, nil is returned when the default file doesn't exist
+func loadPipelineConfig(ctx context.Context, src *dagger.Directory, path string) (*pipelineConfig, error) {
+	matches, err := src.Glob(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		// A config file given explicitly has to exist
+		if path != defaultConfigFile {
+			return nil, fmt.Errorf("the config file '%s' doesn't exist in the source", path)
+		}
+
+		return nil, nil
+	}
+
+	// The yaml is converted to json with yq to be decoded with the standard library
+	content, err := dag.
+		Yq(dag.Directory().WithFile("node.yaml", src.File(path))).
+		Container().
+		WithExec([]string{"--output-format=json", ".", "node.yaml"}, dagger.ContainerWithExecOpts{
+			UseEntrypoint: true,
+		}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("not able to read the config file '%s': %w", path, err)
+	}
+
+	config := &pipelineConfig{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(content)))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file '%s': %w", path, err)
+	}
+
+	err = config.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file '%s': %w", path, err)
+	}
+
+	return config, nil
+}
+
+// validate check the values which can't be enforced by the json decoding
+func (c *pipelineConfig) validate() error {
+	if c.NodeVersion != "" && !nodeVersionRe.MatchString(string(c.NodeVersion)) {
+		return fmt.Errorf("'nodeVersion' has to be a version like 20 or 20.11.1, got '%s'", c.NodeVersion)
+	}
+
+	for point, hooks := range c.Hooks {
+		if point != "pre" && point != "post" && !slices.Contains(hookPoints, point) {
+			return fmt.Errorf("unsupported hook point '%s' in 'hooks' (pre | post | %s)", point, strings.Join(hookPoints, " | "))
+		}
+
+		for _, hook := range hooks {
+			if len(hook) == 0 {
+				return fmt.Errorf("a hook of '%s' can't be empty", point)
+			}
+		}
+	}
+
+	for _, stage := range c.Skip {
+		if !slices.Contains(skippableStages, stage) {
+			return fmt.Errorf("unsupported stage '%s' in 'skip' (lint | test | build | publish | oci)", stage)
+		}
+	}
+
+	for name := range c.Env {
+		if !envVarNameRe.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name '%s' in 'env'", name)
+		}
+	}
+
+	for _, cmd := range c.SystemSetupCmds {
+		if len(cmd) == 0 {
+			return fmt.Errorf("a system setup command can't be empty")
+		}
+	}
+
+	if c.RuntimeImage != nil {
+		if c.RuntimeImage.Image != "slim" && c.RuntimeImage.Image != "distroless" {
+			return fmt.Errorf("unsupported runtime image '%s' in 'runtimeImage.image' (slim | distroless)", c.RuntimeImage.Image)
+		}
+
+		for _, port := range c.RuntimeImage.ExposedPorts {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("invalid port %d in 'runtimeImage.exposedPorts'", port)
+			}
+		}
+
+		for _, cmd := range c.RuntimeImage.SetupCmds {
+			if len(cmd) == 0 {
+				return fmt.Errorf("a runtime setup command can't be empty")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConfigNodeVersion(t *testing.T) {
+	tests := []struct {
+		config  string
+		want    configNodeVersion
+		wantErr bool
+	}{
+		{config: `{"nodeVersion": "20.11.1"}`, want: "20.11.1"},
+		{config: `{"nodeVersion": 20}`, want: "20"},
+		{config: `{"nodeVersion": 20.11}`, want: "20.11"},
+		{config: `{"nodeVersion": null}`, want: ""},
+		{config: `{"nodeVersion": true}`, wantErr: true},
+		{config: `{"nodeVersion": ["20"]}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			config := pipelineConfig{}
+			err := json.Unmarshal([]byte(tt.config), &config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decoding %s = %q, want an error", tt.config, config.NodeVersion)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("decoding %s returned an error: %v", tt.config, err)
+			}
+
+			if config.NodeVersion != tt.want {
+				t.Errorf("decoding %s = %q, want %q", tt.config, config.NodeVersion, tt.want)
+			}
+
+			err = config.validate()
+			if err != nil {
+				t.Errorf("validate() returned an error: %v", err)
+			}
+		})
+	}
+}
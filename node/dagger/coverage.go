@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"main/internal/dagger"
+	"strconv"
+	"strings"
+)
+
+const (
+	coverageDir = "/outputs/coverage"
+	// The c8 release collecting the coverage of the runners without native coverage
+	c8Package = "c8@10.1.3"
+)
+
+// The packages providing the coverage to vitest, one of them has to be installed with the coverage enabled
+var vitestCoverageProviders = []string{
+	"@vitest/coverage-v8",
+	"@vitest/coverage-istanbul",
+}
+
+// The commands adding a development dependency with each package manager
+var addDevDependencyCommands = map[string][]string{
+	"npm":  {"npm", "install", "--no-save"},
+	"pnpm": {"pnpm", "add", "--save-dev"},
+	"yarn": {"env", "YARN_ENABLE_IMMUTABLE_INSTALLS=false", "yarn", "add", "--dev"},
+	"bun":  {"bun", "add", "--dev"},
+}
+
+// The coverage totals extracted from the lcov report
+type coverageTotals struct {
+	LinesFound    int
+	LinesHit      int
+	BranchesFound int
+	BranchesHit   int
+}
+
+func (c coverageTotals) linePercent() float64 {
+	return percent(c.LinesHit, c.LinesFound)
+}
+
+func (c coverageTotals) branchPercent() float64 {
+	return percent(c.BranchesHit, c.BranchesFound)
+}
+
+func percent(hit int, found int) float64 {
+	if found == 0 {
+		return 100
+	}
+
+	return float64(hit) * 100 / float64(found)
+}
+
+// Execute the test command with coverage enabled and return the lcov and cobertura reports
+func (n *Node) Coverage(
+	ctx context.Context,
+	// Fail when the line coverage percentage is below this threshold
+	// +optional
+	lineThreshold float64,
+	// Fail when the branch coverage percentage is below this threshold
+	// +optional
+	branchThreshold float64,
+) (*dagger.Directory, error) {
+	n, err := n.withCoverage(ctx, lineThreshold, branchThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.Ctr.Directory(coverageDir), nil
+}
+
+// withCoverage run the tests with coverage enabled and check the totals against the thresholds
+func (n *Node) withCoverage(ctx context.Context, lineThreshold float64, branchThreshold float64) (*Node, error) {
+	n.Ctr = n.Ctr.WithExec([]string{"mkdir", "-p", coverageDir})
+
+	testCmd := []string{"test"}
+	switch n.TestRunner {
+	case "jest":
+		n.exec(n.testCommand(testCmd, []string{
+			"--coverage",
+			"--coverageReporters=lcov",
+			"--coverageReporters=cobertura",
+			"--coverageReporters=text-summary",
+			"--coverageDirectory=" + coverageDir,
+		}), false)
+	case "vitest":
+		// The tests run in a copy of the container, a coverage provider installed for the run has its own 'node_modules' cache and isn't kept in the project
+		covered, err := n.fork().withVitestCoverageProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		covered.exec(covered.testCommand(testCmd, []string{
+			"--coverage.enabled",
+			"--coverage.reporter=lcov",
+			"--coverage.reporter=cobertura",
+			"--coverage.reporter=text-summary",
+			"--coverage.reportsDirectory=" + coverageDir,
+		}), false)
+
+		n.Ctr = n.Ctr.WithDirectory("/outputs", covered.Ctr.Directory("/outputs"))
+	default:
+		// Other runners are wrapped with c8 which collects the native V8 coverage of every node processes
+		n.exec(append([]string{
+			"npx",
+			"--yes",
+			c8Package,
+			"--reporter=lcov",
+			"--reporter=cobertura",
+			"--reporter=text-summary",
+			"--reports-dir=" + coverageDir,
+		}, n.testCommand(testCmd, nil)...), false)
+	}
+
+	lcov, err := n.Ctr.File(coverageDir + "/lcov.info").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	totals, err := parseLcovTotals(lcov)
+	if err != nil {
+		return nil, err
+	}
+
+	if totals.linePercent() < lineThreshold {
+		return nil, fmt.Errorf("line coverage %.2f%% is below the threshold of %.2f%%", totals.linePercent(), lineThreshold)
+	}
+
+	if totals.branchPercent() < branchThreshold {
+		return nil, fmt.Errorf("branch coverage %.2f%% is below the threshold of %.2f%%", totals.branchPercent(), branchThreshold)
+	}
+
+	return n, nil
+}
+
+// withVitestCoverageProvider install the v8 coverage provider matching the vitest version when the project doesn't have one
+func (n *Node) withVitestCoverageProvider(ctx context.Context) (*Node, error) {
+	content, err := n.Ctr.File(workdir + "/package.json").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgJson struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	err = json.Unmarshal([]byte(content), &pkgJson)
+	if err != nil {
+		return nil, fmt.Errorf("not able to read the package.json: %w", err)
+	}
+
+	for _, provider := range vitestCoverageProviders {
+		_, isDependency := pkgJson.Dependencies[provider]
+		_, isDevDependency := pkgJson.DevDependencies[provider]
+		if isDependency || isDevDependency {
+			return n, nil
+		}
+	}
+
+	versionCmd := []string{"node", "-p", "require('vitest/package.json').version"}
+	if n.YarnPnp {
+		versionCmd = append([]string{"yarn"}, versionCmd...)
+	}
+
+	version, err := n.Ctr.WithExec(versionCmd).Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("no vitest coverage provider in the package.json (%s) and not able to find the vitest version to install one: %w", strings.Join(vitestCoverageProviders, " | "), err)
+	}
+
+	addCmd, ok := addDevDependencyCommands[n.PkgMgr]
+	if !ok {
+		addCmd = addDevDependencyCommands["npm"]
+	}
+
+	// The 'node_modules' cache shared with the other stages keeps only the dependencies of the lockfile
+	if !n.YarnPnp {
+		n.Ctr = n.Ctr.WithMountedCache(workdir+"/node_modules", dag.CacheVolume(n.getModulesCacheKey("node-modules-vitest-coverage")))
+	}
+
+	n.Ctr = n.Ctr.WithExec(append(addCmd, vitestCoverageProviders[0]+"@"+strings.TrimSpace(version)))
+
+	return n, nil
+}
+
+// parseLcovTotals sum the line and branch counters of every files of a lcov report
+func parseLcovTotals(lcov string) (coverageTotals, error) {
+	totals := coverageTotals{}
+
+	for _, line := range strings.Split(lcov, "\n") {
+		key, rawValue, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+
+		var counter *int
+		switch key {
+		case "LF":
+			counter = &totals.LinesFound
+		case "LH":
+			counter = &totals.LinesHit
+		case "BRF":
+			counter = &totals.BranchesFound
+		case "BRH":
+			counter = &totals.BranchesHit
+		default:
+			continue
+		}
+
+		value, err := strconv.Atoi(rawValue)
+		if err != nil {
+			return totals, fmt.Errorf("not able to parse the lcov report line '%s': %w", line, err)
+		}
+		*counter += value
+	}
+
+	return totals, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseLcovTotals(t *testing.T) {
+	tests := []struct {
+		name    string
+		lcov    string
+		want    coverageTotals
+		wantErr bool
+	}{
+		{
+			name: "single file",
+			lcov: `TN:
+SF:src/index.ts
+FN:1,main
+FNF:1
+FNH:1
+DA:1,1
+DA:2,0
+LF:2
+LH:1
+BRDA:2,0,0,1
+BRDA:2,0,1,0
+BRF:2
+BRH:1
+end_of_record
+`,
+			want: coverageTotals{LinesFound: 2, LinesHit: 1, BranchesFound: 2, BranchesHit: 1},
+		},
+		{
+			name: "several files",
+			lcov: `SF:src/a.ts
+LF:10
+LH:8
+BRF:4
+BRH:4
+end_of_record
+SF:src/b.ts
+LF:30
+LH:12
+BRF:6
+BRH:1
+end_of_record
+`,
+			want: coverageTotals{LinesFound: 40, LinesHit: 20, BranchesFound: 10, BranchesHit: 5},
+		},
+		{
+			name: "without branches",
+			lcov: "SF:src/a.js\r\nLF:5\r\nLH:5\r\nend_of_record\r\n",
+			want: coverageTotals{LinesFound: 5, LinesHit: 5},
+		},
+		{
+			name: "empty",
+			lcov: "",
+			want: coverageTotals{},
+		},
+		{
+			name:    "invalid counter",
+			lcov:    "SF:src/a.ts\nLF:ten\nend_of_record\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLcovTotals(tt.lcov)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseLcovTotals() = %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseLcovTotals() returned an error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("parseLcovTotals() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoveragePercent(t *testing.T) {
+	tests := []struct {
+		name       string
+		totals     coverageTotals
+		wantLine   float64
+		wantBranch float64
+	}{
+		{
+			name:       "partial",
+			totals:     coverageTotals{LinesFound: 40, LinesHit: 30, BranchesFound: 8, BranchesHit: 2},
+			wantLine:   75,
+			wantBranch: 25,
+		},
+		{
+			name:       "nothing to cover",
+			totals:     coverageTotals{},
+			wantLine:   100,
+			wantBranch: 100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.totals.linePercent(); got != tt.wantLine {
+				t.Errorf("linePercent() = %v, want %v", got, tt.wantLine)
+			}
+
+			if got := tt.totals.branchPercent(); got != tt.wantBranch {
+				t.Errorf("branchPercent() = %v, want %v", got, tt.wantBranch)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"main/internal/dagger"
+	"strings"
+)
+
+const (
+	e2eOutputDir  = "/outputs/e2e"
+	e2eAppService = "app"
+)
+
+// The directories written by the end-to-end test runners (reports, traces, screenshots and videos)
+var e2eArtifactDirs = []string{
+	"playwright-report",
+	"test-results",
+	"blob-report",
+	"cypress/screenshots",
+	"cypress/videos",
+	"cypress/reports",
+}
+
+// The commands installing the browsers and their system dependencies for each end-to-end test runner
+var e2eBrowserSetupCmds = map[string][][]string{
+	"playwright": {
+		{"npx", "playwright", "install", "--with-deps"},
+	},
+	"cypress": {
+		{"sh", "-c", "apt-get update && apt-get install -y --no-install-recommends libgtk2.0-0 libgtk-3-0 libgbm-dev libnotify-dev libnss3 libxss1 libasound2 libxtst6 xauth xvfb && rm -rf /var/lib/apt/lists/*"},
+		{"npx", "cypress", "install"},
+	},
+}
+
+// Start the application as a service with its dependencies and run the end-to-end tests against it, return the reports, screenshots and videos
+func (n *Node) E2E(
+	ctx context.Context,
+	// The names of the services, they are used as hostnames by the application and the tests
+	// +optional
+	serviceNames []string,
+	// The services the application depends on (e.g. postgres, redis, a mock api), in the same order as the names
+	// +optional
+	services []*dagger.Service,
+	// The port of the application, the tests start once it is reachable
+	// +optional
+	// +default=3000
+	healthPort int,
+	// The script from the package.json starting the application
+	// +optional
+	// +default="start"
+	startScript string,
+	// The script from the package.json running the end-to-end tests
+	// +optional
+	// +default="test:e2e"
+	e2eScript string,
+	// The end-to-end test runner (playwright | cypress), detected by default
+	// +optional
+	runner string,
+	// A container with the source, the dependencies and the browsers to run the tests, a debian based node container is built by default
+	// +optional
+	container *dagger.Container,
+	// Return the reports even when the tests fail, the exit code is written in the 'exit_code' file
+	// +optional
+	ignoreFailure bool,
+) (*dagger.Directory, error) {
+	if len(serviceNames) != len(services) {
+		return nil, fmt.Errorf("each service needs a name, got %d names for %d services", len(serviceNames), len(services))
+	}
+
+	app := n.fork()
+	app.Ctr = withServiceBindings(app.Ctr, serviceNames, services)
+	appService := app.
+		Ctr.
+		WithExposedPort(healthPort).
+		AsService(dagger.ContainerAsServiceOpts{
+			Args: app.scriptCommand([]string{startScript}),
+		})
+
+	if container == nil {
+		var err error
+		container, err = n.e2eContainer(ctx, runner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	baseURL := fmt.Sprintf("http://%s:%d", e2eAppService, healthPort)
+	command := shellJoin(n.scriptCommand([]string{e2eScript}))
+	artifactsCmd := fmt.Sprintf(
+		"mkdir -p %[1]s; for dir in %[2]s; do if [ -e \"$dir\" ]; then mkdir -p \"%[1]s/$(dirname $dir)\" && cp -r \"$dir\" \"%[1]s/$dir\"; fi; done",
+		e2eOutputDir,
+		strings.Join(e2eArtifactDirs, " "),
+	)
+
+	// The application service is started by dagger when bound and the tests wait for its exposed port to be healthy
+	ctr := withServiceBindings(container, serviceNames, services).
+		WithServiceBinding(e2eAppService, appService).
+		WithEnvVariable("CI", "true").
+		WithEnvVariable("BASE_URL", baseURL).
+		WithEnvVariable("CYPRESS_BASE_URL", baseURL).
+		WithExec([]string{
+			"sh",
+			"-c",
+			fmt.Sprintf("%s; exitCode=$?; %s; echo -n $exitCode > %s/exit_code", command, artifactsCmd, e2eOutputDir),
+		})
+
+	if !ignoreFailure {
+		exitCode, err := ctr.File(e2eOutputDir + "/exit_code").Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if exitCode != "0" {
+			output, err := ctr.Stdout(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, fmt.Errorf("the end-to-end tests failed with the exit code %s:\n%s", exitCode, output)
+		}
+	}
+
+	return ctr.Directory(e2eOutputDir), nil
+}
+
+// e2eContainer return a debian based node container with the dependencies and the browsers of the runner, the browsers don't support alpine
+func (n *Node) e2eContainer(ctx context.Context, runner string) (*dagger.Container, error) {
+	if n.Src == nil {
+		return nil, fmt.Errorf("the source has to be set with 'with-source' to run the end-to-end tests")
+	}
+
+	if runner == "" {
+		var err error
+		runner, err = n.
+			nodeAnalyzer(n.Src).
+			GetBrowserTestRunner(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	browserSetupCmds, ok := e2eBrowserSetupCmds[runner]
+	if !ok {
+		return nil, fmt.Errorf("unsupported end-to-end test runner '%s' (playwright | cypress)", runner)
+	}
+
+	baseImageRefParts := strings.Split(n.BaseImageRef, ":")
+	e2e := &Node{
+		PipelineID:    n.PipelineID + "-e2e",
+		PkgMgr:        n.PkgMgr,
+		PkgMgrVersion: n.PkgMgrVersion,
+		Platform:      n.Platform,
+		YarnBerry:     n.YarnBerry,
+		YarnPnp:       n.YarnPnp,
+		Workspaces:    n.Workspaces,
+	}
+
+	e2e = e2e.
+		WithVersion(baseImageRefParts[0], strings.TrimSuffix(baseImageRefParts[1], "-alpine"), false).
+		WithSource(n.Src, false)
+
+	if n.NpmrcToken != nil {
+		e2e = e2e.WithNpmrcTokenEnv(n.NpmrcTokenName, n.NpmrcToken)
+	}
+
+	if n.NpmrcFile != nil {
+		e2e = e2e.WithNpmrcTokenFile(n.NpmrcFile)
+	}
+
+	e2e.Ctr = n.withRegistryAuths(e2e.Ctr)
+
+	if n.NativeAddon {
+		e2e = e2e.WithNativeToolchain()
+	}
+
+	if n.Corepack {
+		e2e = e2e.WithCorepack(n.PkgMgr+"@"+n.PkgMgrVersion, false)
+	} else {
+		e2e = e2e.WithPackageManager(n.PkgMgr, false, n.PkgMgrVersion)
+	}
+
+	e2e, err := e2e.Install(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return e2e.SetupSystem(browserSetupCmds).Ctr, nil
+}
+
+// withServiceBindings bind the services to the container with their names as hostnames
+func withServiceBindings(ctr *dagger.Container, names []string, services []*dagger.Service) *dagger.Container {
+	for idx, service := range services {
+		ctr = ctr.WithServiceBinding(names[idx], service)
+	}
+
+	return ctr
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The points of the pipeline where hooks can run
+var hookPoints = []string{
+	"before-install",
+	"after-install",
+	"before-lint",
+	"after-lint",
+	"before-test",
+	"after-test",
+	"before-build",
+	"after-build",
+	"before-publish",
+	"after-publish",
+}
+
+// A script from the package.json to run at a point of the pipeline
+type PipelineHook struct {
+	// The hook point (before-install | after-install | before-lint | after-lint | before-test | after-test | before-build | after-build | before-publish | after-publish)
+	Point string
+	// The script from the package.json with its arguments
+	Command []string
+}
+
+// Add a hook executed by the pipeline at the hook point, the hooks of a point run in the order they are added
+func (n *Node) WithHook(
+	// The hook point (before-install | after-install | before-lint | after-lint | before-test | after-test | before-build | after-build | before-publish | after-publish)
+	point string,
+	// The script from the package.json with its arguments
+	command []string,
+) (*Node, error) {
+	if !slices.Contains(hookPoints, point) {
+		return nil, fmt.Errorf("unsupported hook point '%s' (%s)", point, strings.Join(hookPoints, " | "))
+	}
+
+	if len(command) == 0 {
+		return nil, fmt.Errorf("the command of the hook can't be empty")
+	}
+
+	n.Hooks = append(n.Hooks, PipelineHook{
+		Point:   point,
+		Command: command,
+	})
+
+	return n, nil
+}
+
+// runHooks run the hooks of the point as stages named after it
+func (r *PipelineResult) runHooks(ctx context.Context, pipeline *Node, hooks []PipelineHook, point string) (*Node, error) {
+	for _, hook := range hooks {
+		if hook.Point != point {
+			continue
+		}
+
+		next, err := r.runStage(ctx, point, func() (*Node, []string, error) {
+			return pipeline.fork().Run(hook.Command, false), nil, nil
+		})
+		if err != nil {
+			return pipeline, err
+		}
+
+		pipeline = next
+	}
+
+	return pipeline, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// The install commands which don't update the lockfile
+var frozenInstallCommands = map[string][]string{
+	"npm":  {"npm", "ci"},
+	"pnpm": {"pnpm", "install", "--frozen-lockfile"},
+	"bun":  {"bun", "install", "--frozen-lockfile"},
+	"yarn": {"sh", "-c", "if yarn --version | grep -q '^1\\.'; then yarn install --frozen-lockfile; else yarn install --immutable; fi"},
+}
+
+// The messages of the package managers when the lockfile doesn't match the package.json or is missing
+var lockfileOutOfSyncRe = regexp.MustCompile(
+	`can only install packages when your package\.json and package-lock\.json` +
+		`|can only install with an existing package-lock\.json` +
+		`|Your lockfile needs to be updated` +
+		`|The lockfile would have been modified by this install` +
+		`|ERR_PNPM_OUTDATED_LOCKFILE|ERR_PNPM_NO_LOCKFILE` +
+		`|lockfile had changes, but lockfile is frozen`,
+)
+
+// LockfileOutOfSyncError is returned by a frozen install when the lockfile doesn't match the package.json
+type LockfileOutOfSyncError struct {
+	PkgMgr string
+	Output string
+}
+
+func (e *LockfileOutOfSyncError) Error() string {
+	return fmt.Sprintf(
+		"the lockfile is out of sync with the package.json, run '%s install' and commit the lockfile:\n%s",
+		e.PkgMgr,
+		e.Output,
+	)
+}
+
+// newInstallError return a LockfileOutOfSyncError when the output of the install matches a lockfile error
+func newInstallError(pkgMgr string, exitCode int, output string) error {
+	if lockfileOutOfSyncRe.MatchString(output) {
+		return &LockfileOutOfSyncError{
+			PkgMgr: pkgMgr,
+			Output: output,
+		}
+	}
+
+	return fmt.Errorf("the install failed with the exit code %d:\n%s", exitCode, output)
+}
@@ -8,7 +8,12 @@ import (
 )
 
 const (
-	workdir = "/opt/app"
+	workdir      = "/opt/app"
+	pnpmStoreDir = "/root/.pnpm-store"
+	bunCacheDir  = "/root/.bun/install/cache"
+	corepackHome = "/root/.cache/node/corepack"
+
+	yarnBerryGlobalFolder = "/root/.yarn/berry"
 )
 
 type Node struct {
@@ -21,6 +26,18 @@ type Node struct {
 	// +private
 	Version string
 	// +private
+	License string
+	// +private
+	RepositoryURL string
+	// +private
+	Revision string
+	// +private
+	Branch string
+	// +private
+	TagStrategies []string
+	// +private
+	TagTemplates []string
+	// +private
 	DetectTest bool
 	// +private
 	DetectPackage bool
@@ -29,6 +46,10 @@ type Node struct {
 	// +private
 	DetectOci bool
 	// +private
+	Dockerfile string
+	// +private
+	Src *dagger.Directory
+	// +private
 	PkgMgr string
 	// +private
 	RootWorkspacePaths []string
@@ -37,12 +58,36 @@ type Node struct {
 	// +private
 	PkgMgrVersion string
 	// +private
+	LockfileHash string
+	// +private
+	Corepack bool
+	// +private
+	YarnBerry bool
+	// +private
+	YarnPnp bool
+	// +private
 	Platform dagger.Platform
 	// +private
 	IsProduction bool
 	// +private
 	SystemSetupCmds [][]string
 	// +private
+	Hooks []PipelineHook
+	// +private
+	PreHooks [][]string
+	// +private
+	PostHooks [][]string
+	// +private
+	SkipStages []string
+	// +private
+	FileContainerArtifacts []string
+	// +private
+	DirectoryContainerArtifacts []string
+	// +private
+	OciRegistries []string
+	// +private
+	NativeAddon bool
+	// +private
 	BaseImageRef string
 	// +private
 	NpmrcTokenName string
@@ -51,7 +96,41 @@ type Node struct {
 	// +private
 	NpmrcFile *dagger.Secret
 	// +private
+	RegistryAuths []RegistryAuth
+	// +private
 	DistName string
+	// +private
+	TestRunner string
+	// +private
+	TestReportEnabled bool
+	// +private
+	TestFiles []string
+	// +private
+	StaticFramework string
+	// +private
+	SbomFormat string
+	// +private
+	DockerConfig *dagger.Secret
+	// +private
+	RuntimeImage string
+	// +private
+	RuntimeCtr *dagger.Container
+	// +private
+	RuntimeUser string
+	// +private
+	RuntimeEntrypoint []string
+	// +private
+	RuntimeCmd []string
+	// +private
+	RuntimeExposedPorts []int
+	// +private
+	RuntimeSetupCmds [][]string
+	// +private
+	AnalyzerPatternExclusions []string
+	// +private
+	AnalyzerInternalImage string
+	// +private
+	AnalyzerNodeVersionIndex *dagger.File
 }
 
 // Define the pipeline id to use
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+)
+
+// The commands installing the toolchain used by node-gyp to compile the native addons
+var nativeToolchainCmds = map[string][]string{
+	"alpine": {"apk", "add", "--no-cache", "python3", "make", "g++"},
+	"debian": {"sh", "-c", "apt-get update && apt-get install -y --no-install-recommends python3 make g++ && rm -rf /var/lib/apt/lists/*"},
+}
+
+// Install the toolchain compiling the native addons (python3, make, g++) in the build container, it is left out of the runtime image built by 'oci-build'
+func (n *Node) WithNativeToolchain() *Node {
+	n.NativeAddon = true
+
+	distribution := "debian"
+	if strings.Contains(n.BaseImageRef, "alpine") {
+		distribution = "alpine"
+	}
+
+	n.Ctr = n.Ctr.WithExec(nativeToolchainCmds[distribution])
+
+	return n
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"golang.org/x/sync/errgroup"
 	"main/internal/dagger"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -28,7 +30,12 @@ func (n *Node) WithVersion(
 	if isAlpine {
 		baseImage += "-alpine"
 	}
-	n.Ctr = dag.Container().From(baseImage).WithExec([]string{"mkdir", "/outputs"})
+	n.Ctr = dag.
+		Container(dagger.ContainerOpts{
+			Platform: n.Platform,
+		}).
+		From(baseImage).
+		WithExec([]string{"mkdir", "/outputs"})
 
 	n.BaseImageRef = baseImage
 
@@ -75,7 +82,11 @@ func (n *Node) WithPackageManager(
 	case "npm":
 		return n.WithNpm(disableCache, version)
 	case "yarn":
-		return n.WithYarn(disableCache, version)
+		return n.WithYarn(disableCache, version, n.YarnBerry, n.YarnPnp)
+	case "pnpm":
+		return n.WithPnpm(disableCache, version)
+	case "bun":
+		return n.WithBun(disableCache, version)
 	default:
 		return n.WithNpm(disableCache, version)
 	}
@@ -117,26 +128,158 @@ func (n *Node) WithYarn(
 	// Define a specific version of npm.
 	// +optional
 	version string,
+	// Indicate the project uses yarn berry (v2+)
+	// +optional
+	berry bool,
+	// Indicate the project uses the Plug'n'Play install strategy of yarn berry, 'node_modules' caches are not mounted
+	// +optional
+	pnp bool,
 ) *Node {
 	n.PkgMgr = "yarn"
+	n.YarnBerry = n.YarnBerry || berry || pnp
+	n.YarnPnp = n.YarnPnp || pnp
 
 	if !disableCache {
+		if n.YarnBerry {
+			// The global folder holds the cache when 'enableGlobalCache' is set, zero-installs keep using '.yarn/cache'
+			n.Ctr = n.
+				Ctr.
+				WithEnvVariable("YARN_GLOBAL_FOLDER", yarnBerryGlobalFolder).
+				WithMountedCache(yarnBerryGlobalFolder, dag.CacheVolume(n.getCacheKey("global-yarn-berry-cache")))
+		} else {
+			n.Ctr = n.
+				Ctr.
+				WithMountedCache("/usr/local/share/.cache/yarn", dag.CacheVolume(n.getCacheKey("global-yarn-cache")))
+		}
+	}
+
+	if version != "" {
+		n.PkgMgrVersion = version
+
 		n.Ctr = n.
 			Ctr.
-			WithMountedCache("/usr/local/share/.cache/yarn", dag.CacheVolume(n.getCacheKey("global-yarn-cache")))
+			WithExec([]string{"yarn", "set", "version", version})
 	}
 
+	return n
+}
+
+// Return the Node container with pnpm setup as an entrypoint and pnpm store cache setup
+func (n *Node) WithPnpm(
+	// Disable mounting cache volumes.
+	// +optional
+	disableCache bool,
+	// Define a specific version of pnpm.
+	// +optional
+	version string,
+) *Node {
+	n.PkgMgr = "pnpm"
+
+	pkg := "pnpm"
 	if version != "" {
 		n.PkgMgrVersion = version
+		pkg += "@" + version
+	}
 
+	if !n.Corepack {
 		n.Ctr = n.
 			Ctr.
-			WithExec([]string{"yarn", "set", "version", version})
+			WithExec([]string{"npm", "install", "-g", pkg})
+	}
+
+	if !disableCache {
+		n.Ctr = n.
+			Ctr.
+			WithEnvVariable("npm_config_store_dir", pnpmStoreDir).
+			WithMountedCache(pnpmStoreDir, dag.CacheVolume(n.getCacheKey("global-pnpm-store")))
 	}
 
 	return n
 }
 
+// Return the Node container with bun setup as an entrypoint and bun install cache setup
+func (n *Node) WithBun(
+	// Disable mounting cache volumes.
+	// +optional
+	disableCache bool,
+	// Define a specific version of bun.
+	// +optional
+	version string,
+) *Node {
+	n.PkgMgr = "bun"
+
+	pkg := "bun"
+	if version != "" {
+		n.PkgMgrVersion = version
+		pkg += "@" + version
+	}
+
+	n.Ctr = n.
+		Ctr.
+		WithExec([]string{"npm", "install", "-g", pkg})
+
+	if !disableCache {
+		n.Ctr = n.
+			Ctr.
+			WithEnvVariable("BUN_INSTALL_CACHE_DIR", bunCacheDir).
+			WithMountedCache(bunCacheDir, dag.CacheVolume(n.getCacheKey("global-bun-cache")))
+	}
+
+	return n
+}
+
+// Return the Node container with corepack enabled and the package manager pinned to the given spec
+func (n *Node) WithCorepack(
+	// The package manager spec as defined in the 'packageManager' field of the package.json (e.g. "yarn@4.1.0" or "pnpm@9.0.0")
+	packageManager string,
+	// Disable mounting cache volumes.
+	// +optional
+	disableCache bool,
+) *Node {
+	name, version := parsePackageManagerSpec(packageManager)
+
+	// bun is not managed by corepack
+	if name == "bun" {
+		return n.WithBun(disableCache, version)
+	}
+
+	n.Corepack = true
+	n.Ctr = n.
+		Ctr.
+		WithEnvVariable("COREPACK_HOME", corepackHome).
+		WithEnvVariable("COREPACK_ENABLE_DOWNLOAD_PROMPT", "0")
+
+	if !disableCache {
+		n.Ctr = n.
+			Ctr.
+			WithMountedCache(corepackHome, dag.CacheVolume(n.getCacheKey("global-corepack-cache")))
+	}
+
+	n.Ctr = n.
+		Ctr.
+		WithExec([]string{"corepack", "enable", name})
+
+	if version != "" {
+		n.Ctr = n.
+			Ctr.
+			WithExec([]string{"corepack", "prepare", name + "@" + version, "--activate"})
+	}
+
+	// The version is already activated by corepack, the package manager setup only handles the cache
+	n = n.WithPackageManager(name, disableCache, "")
+	n.PkgMgrVersion = version
+
+	return n
+}
+
+// parsePackageManagerSpec split a spec like "yarn@4.1.0+sha512.abc" into its name and version without the hash
+func parsePackageManagerSpec(spec string) (string, string) {
+	name, version, _ := strings.Cut(spec, "@")
+	version, _, _ = strings.Cut(version, "+")
+
+	return name, version
+}
+
 // Return the Node container with the source code, 'node_modules' cache set up and workdir set
 func (n *Node) WithSource(
 	// The source code
@@ -145,6 +288,8 @@ func (n *Node) WithSource(
 	// +optional
 	persisted bool,
 ) *Node {
+	n.Src = src
+
 	if persisted {
 		n.Ctr = n.
 			Ctr.
@@ -156,18 +301,49 @@ func (n *Node) WithSource(
 			WithMountedDirectory(workdir, src)
 	}
 
+	n.Ctr = n.Ctr.WithWorkdir(workdir)
+
+	return n.withModulesCaches()
+}
+
+// Add a hash of the lockfile, the package manager and the node version in the 'node_modules' cache keys, it has to be called once the source and the package manager are set
+func (n *Node) WithLockfileCacheKey(ctx context.Context) (*Node, error) {
+	if n.Src == nil {
+		return nil, fmt.Errorf("the source has to be set with 'with-source' to hash the lockfile")
+	}
+
+	if n.PkgMgr == "" {
+		return nil, fmt.Errorf("the package manager has to be set before hashing the lockfile")
+	}
+
+	var err error
+	n.LockfileHash, err = n.lockfileHash(ctx, n.Src)
+	if err != nil {
+		return nil, err
+	}
+
+	// The caches mounted by 'with-source' are replaced by the ones scoped to the lockfile hash
+	return n.withModulesCaches(), nil
+}
+
+// withModulesCaches mount the 'node_modules' caches of the project and its workspaces
+func (n *Node) withModulesCaches() *Node {
+	// Plug'n'Play doesn't use 'node_modules'
+	if n.YarnPnp {
+		return n
+	}
+
 	for _, rootPath := range n.RootWorkspacePaths {
 		for _, workspace := range n.Workspaces {
 			n.Ctr = n.
 				Ctr.
-				WithMountedCache(filepath.Clean(workdir+"/"+rootPath+"/"+workspace+"/node_modules"), dag.CacheVolume(n.getCacheKey(rootPath+"-"+workspace+"-node-modules")))
+				WithMountedCache(filepath.Clean(workdir+"/"+rootPath+"/"+workspace+"/node_modules"), dag.CacheVolume(n.getModulesCacheKey(rootPath+"-"+workspace+"-node-modules")))
 		}
 	}
 
 	n.Ctr = n.
 		Ctr.
-		WithMountedCache(workdir+"/node_modules", dag.CacheVolume(n.getCacheKey("node-modules"))).
-		WithWorkdir(workdir)
+		WithMountedCache(workdir+"/node_modules", dag.CacheVolume(n.getModulesCacheKey("node-modules")))
 
 	return n
 }
@@ -264,6 +440,13 @@ func (n *Node) WithWorkspace(workspace string) *Node {
 	return n
 }
 
+// fork return a copy of the node which can be modified without altering the current one
+func (n *Node) fork() *Node {
+	forked := *n
+
+	return &forked
+}
+
 func (n *Node) prepareWorkspaceNpmOption() []string {
 	options := []string{}
 	for _, i := range n.Workspaces {
@@ -282,6 +465,28 @@ func (n *Node) prepareWorkspaceYarnOption() []string {
 	return options
 }
 
+func (n *Node) prepareWorkspaceFilterOption() []string {
+	options := []string{}
+	for _, i := range n.Workspaces {
+		options = append(options, "--filter="+i)
+	}
+
+	return options
+}
+
+// withScriptArgs append arguments to forward to the script of a package.json command
+func (n *Node) withScriptArgs(command []string, args []string) []string {
+	if len(args) == 0 {
+		return command
+	}
+
+	if n.PkgMgr == "npm" {
+		command = append(command, "--")
+	}
+
+	return append(command, args...)
+}
+
 // Execute a command from the package.json
 func (n *Node) Run(
 	// Command from the package.json to run
@@ -289,6 +494,11 @@ func (n *Node) Run(
 	// Indicate if we want to capture in /outputs the stdout + exit code in order to extract the folder
 	captureOutput bool,
 ) *Node {
+	return n.exec(n.scriptCommand(command), captureOutput)
+}
+
+// scriptCommand return the package manager command running a script from the package.json in the selected workspaces
+func (n *Node) scriptCommand(command []string) []string {
 	baseCommand := []string{n.PkgMgr}
 
 	if n.Workspaces != nil {
@@ -296,35 +506,106 @@ func (n *Node) Run(
 		case "npm":
 			baseCommand = append(baseCommand, n.prepareWorkspaceNpmOption()...)
 		case "yarn":
+			if len(n.Workspaces) > 1 {
+				return n.yarnWorkspacesCommand(command)
+			}
 			baseCommand = append(baseCommand, n.prepareWorkspaceYarnOption()...)
+		case "pnpm", "bun":
+			baseCommand = append(baseCommand, n.prepareWorkspaceFilterOption()...)
 		default:
 			baseCommand = append(baseCommand, n.prepareWorkspaceNpmOption()...)
 		}
 	}
 
-	baseCommand = append(append(baseCommand, "run"), command...)
+	return append(append(baseCommand, "run"), command...)
+}
 
-	if captureOutput {
-		baseCommand = []string{
-			"sh",
-			"-c",
-			fmt.Sprintf(
-				"{ %s 2>&1; echo -n $? > /outputs/exit_code; } | tee /outputs/output.txt",
-				strings.Join(baseCommand, " "),
-			),
+// yarnWorkspacesCommand return the command running a script in each selected workspace, 'yarn workspace' accepts a single workspace
+func (n *Node) yarnWorkspacesCommand(command []string) []string {
+	if n.YarnBerry {
+		cmd := []string{"yarn", "workspaces", "foreach", "--all"}
+		for _, workspace := range n.Workspaces {
+			cmd = append(cmd, "--include", workspace)
 		}
+
+		return append(append(cmd, "run"), command...)
+	}
+
+	// Yarn classic can't filter 'yarn workspaces run', the workspaces run one after the other
+	var runs []string
+	for _, workspace := range n.Workspaces {
+		runs = append(runs, shellJoin(append([]string{"yarn", "workspace", workspace, "run"}, command...)))
 	}
 
+	return []string{"sh", "-c", strings.Join(runs, " && ")}
+}
+
+// exec execute a command in the container, optionally capturing the output in /outputs
+func (n *Node) exec(cmd []string, captureOutput bool) *Node {
 	n.Ctr = n.
 		Ctr.
-		WithExec(baseCommand)
+		WithExec(execCommand(cmd, captureOutput))
 	return n
 }
 
+// execCommand return the command writing its output and exit code in /outputs when captured
+func execCommand(cmd []string, captureOutput bool) []string {
+	if !captureOutput {
+		return cmd
+	}
+
+	return []string{
+		"sh",
+		"-c",
+		fmt.Sprintf(
+			"{ %s 2>&1; echo -n $? > /outputs/exit_code; } | tee /outputs/output.txt",
+			shellJoin(cmd),
+		),
+	}
+}
+
 // Install node modules
-func (n *Node) Install() *Node {
-	n.Ctr = n.Ctr.WithExec([]string{n.PkgMgr, "install"})
-	return n
+func (n *Node) Install(
+	ctx context.Context,
+	// Install exactly the lockfile without updating it (npm ci, --frozen-lockfile, --immutable), fail when the lockfile is out of sync with the package.json
+	// +optional
+	frozen bool,
+) (*Node, error) {
+	if !frozen {
+		n.Ctr = n.Ctr.WithExec([]string{n.PkgMgr, "install"})
+		return n, nil
+	}
+
+	installCmd, ok := frozenInstallCommands[n.PkgMgr]
+	if !ok {
+		installCmd = frozenInstallCommands["npm"]
+	}
+
+	ctr := n.Ctr.WithExec(installCmd, dagger.ContainerWithExecOpts{
+		Expect: dagger.ReturnTypeAny,
+	})
+
+	exitCode, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 {
+		stderr, err := ctr.Stderr(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		stdout, err := ctr.Stdout(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, newInstallError(n.PkgMgr, exitCode, stdout+stderr)
+	}
+
+	n.Ctr = ctr
+	return n, nil
 }
 
 // Execute lint command
@@ -339,33 +620,124 @@ func (n *Node) Lint(
 
 // Execute test command
 func (n *Node) Test(
+	ctx context.Context,
 	// Indicate if we want to capture in /outputs the stdout + exit code in order to extract the folder
 	// +optional
 	// +default=false
 	captureOutput bool,
-) *Node {
-	return n.Run([]string{"test"}, captureOutput)
+	// Return the container with the test reports even when the tests fail
+	// +optional
+	ignoreFailure bool,
+) (*Node, error) {
+	err := n.runTests(ctx, n.testCommand([]string{"test"}, nil), captureOutput)
+	if err != nil && !(ignoreFailure && isTestFailure(err)) {
+		return nil, err
+	}
+
+	return n, nil
 }
 
-// Execute test commands in parallel
+// Execute test commands in parallel, the test reports of each command are merged in the returned container
 func (n *Node) ParallelTest(
 	ctx context.Context,
+	// The commands from the package.json to run, ignored when the tests are sharded
+	// +optional
 	cmds [][]string,
 	// Indicate if we want to capture in /outputs the stdout + exit code in order to extract the folder
 	// +optional
 	// +default=false
 	captureOutput bool,
-) error {
+	// Split the test files in this number of shards running the 'test' command in their own container
+	// +optional
+	shards int,
+	// The test files to split in shards, the detected ones are used by default
+	// +optional
+	testFiles []string,
+	// Limit the number of commands or shards running at once, unlimited by default
+	// +optional
+	maxParallel int,
+	// Return the container with the merged test reports even when some tests fail
+	// +optional
+	ignoreFailure bool,
+) (*Node, error) {
 	var eg errgroup.Group
 
-	for _, cmd := range cmds {
+	if maxParallel > 0 {
+		eg.SetLimit(maxParallel)
+	}
+
+	var runs []*Node
+	var runCmds [][]string
+	var runNames []string
+	if shards > 0 {
+		if testFiles == nil {
+			testFiles = n.TestFiles
+		}
+
+		if len(testFiles) == 0 {
+			return nil, fmt.Errorf("no test files to split in shards")
+		}
+
+		for idx, files := range splitShards(testFiles, shards) {
+			run := n.fork()
+			runs = append(runs, run)
+			runCmds = append(runCmds, run.testCommand([]string{"test"}, files))
+			runNames = append(runNames, fmt.Sprintf("shard %d/%d", idx+1, shards))
+		}
+	} else {
+		for _, cmd := range cmds {
+			run := n.fork()
+			runs = append(runs, run)
+			runCmds = append(runCmds, run.testCommand(cmd, nil))
+			runNames = append(runNames, strings.Join(cmd, " "))
+		}
+	}
+
+	runErrs := make([]error, len(runs))
+	for idx, run := range runs {
 		eg.Go(func() error {
-			_, err := n.Run(cmd, captureOutput).Do(ctx)
-			return err
+			runErrs[idx] = run.runTests(ctx, runCmds[idx], captureOutput)
+			return nil
 		})
 	}
+	_ = eg.Wait()
 
-	return eg.Wait()
+	var failures []error
+	for idx, runErr := range runErrs {
+		if runErr != nil {
+			failures = append(failures, fmt.Errorf("%s failed: %w", runNames[idx], runErr))
+		}
+	}
+
+	// The reports of the failing runs are merged too, only a run which didn't execute has no report
+	if n.TestReportEnabled {
+		for idx, run := range runs {
+			if runErrs[idx] == nil || isTestFailure(runErrs[idx]) {
+				n.Ctr = n.Ctr.WithDirectory(reportsDir, run.Ctr.Directory(reportsDir))
+			}
+		}
+	}
+
+	if len(failures) == 0 {
+		return n, nil
+	}
+
+	// Only the failing tests are ignored, a run which couldn't execute is still an error
+	if ignoreFailure && !slices.ContainsFunc(runErrs, func(err error) bool { return err != nil && !isTestFailure(err) }) {
+		return n, nil
+	}
+
+	return nil, errors.Join(failures...)
+}
+
+// splitShards distribute the files in a round-robin way across at most the given number of shards
+func splitShards(files []string, shards int) [][]string {
+	split := make([][]string, min(shards, len(files)))
+	for idx, file := range files {
+		split[idx%len(split)] = append(split[idx%len(split)], file)
+	}
+
+	return split
 }
 
 // Execute clean command
@@ -388,8 +760,9 @@ func (n *Node) Build(
 	return n.Run([]string{"build"}, captureOutput)
 }
 
-// Execute the publish which push a package to a registry
+// Execute the publish which push a package to a registry, the package tarball is packed in /outputs/package with its checksum
 func (n *Node) Publish(
+	ctx context.Context,
 	// Define permission on the package in the registry
 	// +optional
 	access string,
@@ -399,8 +772,90 @@ func (n *Node) Publish(
 	// Indicate to dry run the publishing
 	// +optional
 	dryRun bool,
-) *Node {
+	// Publish with a provenance attestation (npm, pnpm and yarn berry), the CI environment variables have to be set with 'with-env-var'
+	// +optional
+	provenance bool,
+	// An OIDC token used for the provenance outside of GitHub Actions (e.g. GitLab) and the keyless signing, it is exposed as SIGSTORE_ID_TOKEN
+	// +optional
+	oidcToken *dagger.Secret,
+	// The GitHub Actions 'ACTIONS_ID_TOKEN_REQUEST_URL' used by the package manager to request its OIDC token for the provenance
+	// +optional
+	oidcRequestUrl *dagger.Secret,
+	// The GitHub Actions 'ACTIONS_ID_TOKEN_REQUEST_TOKEN' used by the package manager to request its OIDC token for the provenance
+	// +optional
+	oidcRequestToken *dagger.Secret,
+	// Sign the package tarball with cosign, with the signing key or keyless with the OIDC token
+	// +optional
+	sign bool,
+	// A cosign private key to sign the package tarball
+	// +optional
+	signingKey *dagger.Secret,
+	// The password of the cosign private key
+	// +optional
+	signingKeyPassword *dagger.Secret,
+) (*Node, error) {
+	if provenance && n.PkgMgr == "bun" {
+		return nil, fmt.Errorf("the provenance is not supported by bun")
+	}
+
+	if sign && signingKey == nil && oidcToken == nil {
+		return nil, fmt.Errorf("signing the package requires a signing key or an OIDC token")
+	}
+
+	// 'yarn npm publish' packs the project folder again, the published tarball wouldn't be the signed one
+	if sign && n.YarnBerry {
+		return nil, fmt.Errorf("signing the package is not supported by yarn berry which publishes the project folder instead of the signed tarball")
+	}
+
+	if (oidcRequestUrl == nil) != (oidcRequestToken == nil) {
+		return nil, fmt.Errorf("the OIDC request url and token of GitHub Actions have to be given together")
+	}
+
+	if oidcToken != nil {
+		n.Ctr = n.Ctr.WithSecretVariable("SIGSTORE_ID_TOKEN", oidcToken)
+	}
+
+	if oidcRequestUrl != nil {
+		n.Ctr = n.
+			Ctr.
+			WithSecretVariable("ACTIONS_ID_TOKEN_REQUEST_URL", oidcRequestUrl).
+			WithSecretVariable("ACTIONS_ID_TOKEN_REQUEST_TOKEN", oidcRequestToken)
+	}
+
+	tarball, err := n.pack(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if sign {
+		n.Ctr = n.withPackageSignature(tarball, oidcToken, signingKey, signingKeyPassword)
+	}
+
+	publishCmd := n.publishCommand(access, devTag, dryRun)
+	if provenance {
+		publishCmd = append(publishCmd, "--provenance")
+	}
+
+	// The signed tarball is published, yarn berry only publishes the project folder
+	if !n.YarnBerry {
+		publishCmd = append(publishCmd, packageDir+"/"+tarball)
+	}
+
+	n.Ctr = n.Ctr.WithExec(publishCmd)
+
+	if n.SbomFormat != "" {
+		n.Ctr = n.Ctr.WithFile(sbomOutputDir+"/"+sbomName, generateSbom(n.Ctr.Directory(workdir), n.SbomFormat))
+	}
+
+	return n, nil
+}
+
+// publishCommand return the publish command of the package manager with the options
+func (n *Node) publishCommand(access string, devTag string, dryRun bool) []string {
 	publishCmd := []string{n.PkgMgr, "publish"}
+	if n.YarnBerry {
+		publishCmd = []string{"yarn", "npm", "publish"}
+	}
 
 	if access != "" {
 		publishCmd = append(publishCmd, []string{"--access", access}...)
@@ -414,8 +869,7 @@ func (n *Node) Publish(
 		publishCmd = append(publishCmd, []string{"--dry-run", strconv.FormatBool(dryRun)}...)
 	}
 
-	n.Ctr = n.Ctr.WithExec(publishCmd)
-	return n
+	return publishCmd
 }
 
 // Bump the package version
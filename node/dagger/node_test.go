@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitShards(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []string
+		shards int
+		want   [][]string
+	}{
+		{
+			name:   "even",
+			files:  []string{"a.test.ts", "b.test.ts", "c.test.ts", "d.test.ts"},
+			shards: 2,
+			want:   [][]string{{"a.test.ts", "c.test.ts"}, {"b.test.ts", "d.test.ts"}},
+		},
+		{
+			name:   "uneven",
+			files:  []string{"a.test.ts", "b.test.ts", "c.test.ts", "d.test.ts", "e.test.ts"},
+			shards: 3,
+			want:   [][]string{{"a.test.ts", "d.test.ts"}, {"b.test.ts", "e.test.ts"}, {"c.test.ts"}},
+		},
+		{
+			name:   "single shard",
+			files:  []string{"a.test.ts", "b.test.ts"},
+			shards: 1,
+			want:   [][]string{{"a.test.ts", "b.test.ts"}},
+		},
+		{
+			name:   "fewer files than shards",
+			files:  []string{"a.test.ts", "b.test.ts"},
+			shards: 4,
+			want:   [][]string{{"a.test.ts"}, {"b.test.ts"}},
+		},
+		{
+			name:   "no files",
+			files:  nil,
+			shards: 3,
+			want:   [][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitShards(tt.files, tt.shards)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitShards(%v, %d) = %v, want %v", tt.files, tt.shards, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		node Node
+		want []string
+	}{
+		{
+			name: "root",
+			node: Node{PkgMgr: "npm"},
+			want: []string{"npm", "run", "lint"},
+		},
+		{
+			name: "npm workspaces",
+			node: Node{PkgMgr: "npm", Workspaces: []string{"a", "b"}},
+			want: []string{"npm", "--workspace=a", "--workspace=b", "run", "lint"},
+		},
+		{
+			name: "pnpm workspaces",
+			node: Node{PkgMgr: "pnpm", Workspaces: []string{"a", "b"}},
+			want: []string{"pnpm", "--filter=a", "--filter=b", "run", "lint"},
+		},
+		{
+			name: "yarn single workspace",
+			node: Node{PkgMgr: "yarn", Workspaces: []string{"a"}},
+			want: []string{"yarn", "workspace", "a", "run", "lint"},
+		},
+		{
+			name: "yarn classic workspaces",
+			node: Node{PkgMgr: "yarn", Workspaces: []string{"@scope/a", "b"}},
+			want: []string{"sh", "-c", "yarn workspace @scope/a run lint && yarn workspace b run lint"},
+		},
+		{
+			name: "yarn berry workspaces",
+			node: Node{PkgMgr: "yarn", YarnBerry: true, Workspaces: []string{"a", "b"}},
+			want: []string{"yarn", "workspaces", "foreach", "--all", "--include", "a", "--include", "b", "run", "lint"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.node.scriptCommand([]string{"lint"})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scriptCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"npm", "run", "test", "--", "--reporter=junit"}, want: "npm run test -- --reporter=junit"},
+		{args: []string{"sh", "-c", "yarn workspace a run lint && yarn workspace b run lint"}, want: "sh -c 'yarn workspace a run lint && yarn workspace b run lint'"},
+		{args: []string{"echo", "it's"}, want: `echo 'it'\''s'`},
+		{args: []string{"echo", ""}, want: "echo ''"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got := shellJoin(tt.args)
+			if got != tt.want {
+				t.Errorf("shellJoin(%q) = %s, want %s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
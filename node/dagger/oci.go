@@ -6,9 +6,80 @@ import (
 	"github.com/google/uuid"
 	"golang.org/x/sync/errgroup"
 	"main/internal/dagger"
+	"maps"
+	"slices"
 	"strings"
+	"time"
 )
 
+// The non-root user used by default in the runtime images
+var defaultRuntimeUsers = map[string]string{
+	"slim":       "node",
+	"distroless": "nonroot",
+	"custom":     "1000",
+}
+
+// Use a minimal runtime image for the production image built by 'oci-build' instead of the full node image
+func (n *Node) WithRuntimeImage(
+	// The runtime image to use (slim | distroless), ignored when a container is given
+	// +optional
+	image string,
+	// A custom container to use as runtime image, the image can only be built for the platform of this container
+	// +optional
+	container *dagger.Container,
+	// The non-root user running the application, default to 'node' for slim, 'nonroot' for distroless and '1000' for a custom container
+	// +optional
+	user string,
+	// The entrypoint of the image
+	// +optional
+	entrypoint []string,
+	// The default arguments of the image
+	// +optional
+	cmd []string,
+	// The ports exposed by the image
+	// +optional
+	exposedPorts []int,
+	// The system commands to run as root in the runtime image (e.g. installing curl with apt-get for slim), distroless has no shell to run them
+	// +optional
+	setupCmds [][]string,
+) (*Node, error) {
+	switch {
+	case container != nil:
+		n.RuntimeImage = "custom"
+		n.RuntimeCtr = container
+	case image == "slim" || image == "distroless":
+		n.RuntimeImage = image
+	default:
+		return nil, fmt.Errorf("unsupported runtime image '%s' (slim | distroless)", image)
+	}
+
+	if n.RuntimeImage == "distroless" && len(setupCmds) > 0 {
+		return nil, fmt.Errorf("the distroless runtime image has no shell to run system commands, use the slim or a custom runtime image")
+	}
+
+	// The slim image is debian based, the alpine package manager isn't available
+	if n.RuntimeImage == "slim" {
+		for _, cmd := range setupCmds {
+			if len(cmd) > 0 && cmd[0] == "apk" {
+				return nil, fmt.Errorf("the slim runtime image is debian based, '%s' has to use apt-get", strings.Join(cmd, " "))
+			}
+		}
+	}
+
+	n.RuntimeSetupCmds = setupCmds
+
+	if user == "" {
+		user = defaultRuntimeUsers[n.RuntimeImage]
+	}
+
+	n.RuntimeUser = user
+	n.RuntimeEntrypoint = entrypoint
+	n.RuntimeCmd = cmd
+	n.RuntimeExposedPorts = exposedPorts
+
+	return n, nil
+}
+
 // Build a production image and push to one or more registries
 func (n *Node) OciBuild(
 	ctx context.Context,
@@ -31,6 +102,125 @@ func (n *Node) OciBuild(
 	// +optional
 	// +default="60m"
 	ttl string,
+	// Define the platforms to build the image for, the image is published as a multi-arch manifest list (e.g. linux/amd64, linux/arm64)
+	// +optional
+	platforms []dagger.Platform,
+	// Install exactly the lockfile in the production image and fail when it is out of sync with the package.json
+	// +optional
+	// +default=true
+	frozenLockfile bool,
+) ([]string, error) {
+	if n.DistName == "" {
+		n.DistName = "dist"
+	}
+
+	if len(platforms) == 0 {
+		platforms = []dagger.Platform{n.Platform}
+	}
+
+	err := n.checkRuntimePlatforms(ctx, platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := n.productionSources(ctx, fileContainerArtifacts, directoryContainerArtifacts)
+	if err != nil {
+		return nil, err
+	}
+
+	platformVariants := make([]*dagger.Container, len(platforms))
+	for idx, platform := range platforms {
+		production, err := n.productionBuild(ctx, platform, src, frozenLockfile)
+		if err != nil {
+			return nil, err
+		}
+
+		platformVariants[idx] = n.runtimeContainer(platform, production)
+	}
+
+	return n.publishImage(ctx, platformVariants, registries, isTtl, ttlRegistry, ttl)
+}
+
+// Build an image from a Dockerfile of the source and push to one or more registries
+func (n *Node) DockerBuild(
+	ctx context.Context,
+	// Define registries where to push the image
+	registries []string,
+	// The path of the Dockerfile in the source, the detected one is used by default
+	// +optional
+	dockerfile string,
+	// Build arguments to pass to the build (KEY=VALUE)
+	// +optional
+	buildArgs []string,
+	// The target stage to build
+	// +optional
+	target string,
+	// Secrets to expose to the build, they are available with their name as id
+	// +optional
+	secrets []*dagger.Secret,
+	// Define the ttl registry to use
+	// +optional
+	isTtl bool,
+	// Define the ttl registry to use
+	// +optional
+	// +default="ttl.sh"
+	ttlRegistry string,
+	// Define the ttl in the ttl registry
+	// +optional
+	// +default="60m"
+	ttl string,
+	// Define the platforms to build the image for (e.g. linux/amd64, linux/arm64)
+	// +optional
+	platforms []dagger.Platform,
+) ([]string, error) {
+	if dockerfile == "" {
+		dockerfile = n.Dockerfile
+	}
+
+	if dockerfile == "" {
+		return nil, fmt.Errorf("no dockerfile found in the source")
+	}
+
+	if n.Src == nil {
+		return nil, fmt.Errorf("the source has to be set with 'with-source' to build a dockerfile")
+	}
+
+	var dockerBuildArgs []dagger.BuildArg
+	for _, buildArg := range buildArgs {
+		name, value, found := strings.Cut(buildArg, "=")
+		if !found {
+			return nil, fmt.Errorf("the build argument '%s' has to be in the format KEY=VALUE", buildArg)
+		}
+
+		dockerBuildArgs = append(dockerBuildArgs, dagger.BuildArg{Name: name, Value: value})
+	}
+
+	if len(platforms) == 0 {
+		platforms = []dagger.Platform{n.Platform}
+	}
+
+	platformVariants := make([]*dagger.Container, len(platforms))
+	for idx, platform := range platforms {
+		platformVariants[idx] = n.Src.DockerBuild(dagger.DirectoryDockerBuildOpts{
+			Dockerfile: dockerfile,
+			Platform:   platform,
+			BuildArgs:  dockerBuildArgs,
+			Target:     target,
+			Secrets:    secrets,
+		})
+	}
+
+	return n.publishImage(ctx, platformVariants, registries, isTtl, ttlRegistry, ttl)
+}
+
+// publishImage push the image to the registries, several platform variants are published as a multi-arch manifest list
+func (n *Node) publishImage(
+	ctx context.Context,
+	platformVariants []*dagger.Container,
+	registries []string,
+	isTtl bool,
+	ttlRegistry string,
+	ttl string,
 ) ([]string, error) {
 	var err error
 	var eg errgroup.Group
@@ -38,30 +228,103 @@ func (n *Node) OciBuild(
 
 	result := make(chan string)
 
-	if n.DistName == "" {
-		n.DistName = "dist"
+	var refs []string
+	if isTtl {
+		refs = []string{fmt.Sprintf("%s/%s:%s", ttlRegistry, uuid.New().String(), ttl)}
+	} else {
+		tags, err := n.imageTags()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, registry := range registries {
+			for _, tag := range tags {
+				refs = append(refs, fmt.Sprintf("%s/%s:%s", registry, n.Name, tag))
+			}
+		}
 	}
 
-	if isTtl {
-		registries = []string{ttlRegistry}
+	for idx, variant := range platformVariants {
+		platformVariants[idx] = n.withImageMetadata(variant)
 	}
 
-	productionBuild := &Node{
-		PipelineID:      n.PipelineID,
-		PkgMgr:          n.PkgMgr,
-		Platform:        n.Platform,
-		SystemSetupCmds: n.SystemSetupCmds,
-		DistName:        n.DistName,
-		Ctr: dag.
-			Container(dagger.ContainerOpts{
-				Platform: n.Platform,
-			}),
+	publisher := platformVariants[0]
+	publishOpts := dagger.ContainerPublishOpts{}
+	if len(platformVariants) > 1 {
+		publisher = dag.Container()
+		publishOpts.PlatformVariants = platformVariants
 	}
 
-	baseImageRefParts := strings.Split(n.BaseImageRef, ":")
-	productionBuild = productionBuild.
-		WithVersion(baseImageRefParts[0], baseImageRefParts[1], false)
+	var sbom *dagger.File
+	if n.SbomFormat != "" {
+		// The dependencies are the same for every platform, the first variant is scanned
+		sbom = generateSbom(platformVariants[0].Rootfs(), n.SbomFormat)
+	}
+
+	for _, ref := range refs {
+		eg.Go(func() error {
+			ref, err := publisher.Publish(ctx, ref, publishOpts)
+			result <- ref
+			return err
+		})
+	}
 
+	go func() {
+		err = eg.Wait()
+		close(result)
+	}()
+
+	for res := range result {
+		if res != "" {
+			fullyQualifiedImageNames = append(fullyQualifiedImageNames, res)
+		}
+	}
+
+	if err != nil || sbom == nil {
+		return fullyQualifiedImageNames, err
+	}
+
+	// The tags of a registry share the same digest, the sbom is attached once to it
+	var attachGroup errgroup.Group
+	for _, subject := range sbomSubjects(fullyQualifiedImageNames) {
+		attachGroup.Go(func() error {
+			return n.attachSbom(ctx, subject, sbom)
+		})
+	}
+
+	return fullyQualifiedImageNames, attachGroup.Wait()
+}
+
+// withImageMetadata add the OCI standard labels and annotations extracted from the package.json and the git information
+func (n *Node) withImageMetadata(ctr *dagger.Container) *dagger.Container {
+	metadata := map[string]string{
+		"org.opencontainers.image.created":  time.Now().UTC().Format(time.RFC3339),
+		"org.opencontainers.image.title":    n.Name,
+		"org.opencontainers.image.version":  n.Version,
+		"org.opencontainers.image.source":   n.RepositoryURL,
+		"org.opencontainers.image.revision": n.Revision,
+		"org.opencontainers.image.licenses": n.License,
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		if metadata[key] == "" {
+			continue
+		}
+
+		ctr = ctr.
+			WithLabel(key, metadata[key]).
+			WithAnnotation(key, metadata[key])
+	}
+
+	return ctr
+}
+
+// productionSources return the directory to install the production image from, with the build artifacts, the package.json and the lockfile
+func (n *Node) productionSources(
+	ctx context.Context,
+	fileContainerArtifacts []string,
+	directoryContainerArtifacts []string,
+) (*dagger.Directory, error) {
 	ctrDirArtifacts := append(
 		[]string{
 			n.DistName,
@@ -78,66 +341,226 @@ func (n *Node) OciBuild(
 
 	if n.NpmrcToken != nil {
 		ctrFileArtifacts = append(ctrFileArtifacts, ".npmrc")
-		productionBuild = productionBuild.WithNpmrcTokenEnv(n.NpmrcTokenName, n.NpmrcToken)
 	}
 
-	if n.NpmrcFile != nil {
-		productionBuild = productionBuild.WithNpmrcTokenFile(n.NpmrcFile)
+	pkgMgrLockfiles, ok := packageManagerLockfiles[n.PkgMgr]
+	if !ok {
+		pkgMgrLockfiles = packageManagerLockfiles["npm"]
 	}
 
-	switch n.PkgMgr {
-	case "npm":
-		ctrFileArtifacts = append(ctrFileArtifacts, "package-lock.json")
-	case "yarn":
-		ctrFileArtifacts = append(ctrFileArtifacts, "yarn.lock")
-	default:
-		ctrFileArtifacts = append(ctrFileArtifacts, "package-lock.json")
+	// Only the lockfiles of the source are copied, bun writes 'bun.lock' since 1.2 and 'bun.lockb' before
+	entries, err := n.Ctr.Directory(workdir).Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lockfile := range pkgMgrLockfiles {
+		if slices.Contains(entries, lockfile) {
+			ctrFileArtifacts = append(ctrFileArtifacts, lockfile)
+		}
 	}
 
-	productionBuild.Ctr = productionBuild.Ctr.WithWorkdir(workdir)
+	src := dag.Directory()
 
 	for _, name := range ctrDirArtifacts {
-		path := workdir + "/" + name
-		productionBuild.Ctr = productionBuild.
-			Ctr.
-			WithDirectory(path, n.Ctr.Directory(path))
+		src = src.WithDirectory(name, n.Ctr.Directory(workdir+"/"+name))
 	}
 
 	for _, name := range ctrFileArtifacts {
-		path := workdir + "/" + name
-		productionBuild.Ctr = productionBuild.
-			Ctr.
-			WithFile(path, n.Ctr.File(path))
+		src = src.WithFile(name, n.Ctr.File(workdir+"/"+name))
+	}
+
+	if n.YarnBerry {
+		// The optional yarn berry files, '.pnp.cjs' and '.yarn' replace 'node_modules' with Plug'n'Play
+		src = src.WithDirectory(".", n.Ctr.Directory(workdir), dagger.DirectoryWithDirectoryOpts{
+			Include: []string{".yarnrc.yml", ".pnp.cjs", ".pnp.loader.mjs", ".yarn/**"},
+		})
+	}
+
+	return src, nil
+}
+
+// productionBuild return a production container for the platform with the production dependencies of the sources installed
+func (n *Node) productionBuild(
+	ctx context.Context,
+	platform dagger.Platform,
+	src *dagger.Directory,
+	frozenLockfile bool,
+) (*Node, error) {
+	productionBuild := &Node{
+		PipelineID: n.PipelineID,
+		PkgMgr:     n.PkgMgr,
+		Platform:   platform,
+		DistName:   n.DistName,
+		YarnBerry:  n.YarnBerry,
+		YarnPnp:    n.YarnPnp,
+		Ctr: dag.
+			Container(dagger.ContainerOpts{
+				Platform: platform,
+			}),
+	}
+
+	baseImageRefParts := strings.Split(n.BaseImageRef, ":")
+	version, err := n.productionImageVersion(ctx, baseImageRefParts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// The system commands are written for the base image of the build, they are only run on the same image, the runtime image has its own
+	if version == baseImageRefParts[1] {
+		productionBuild.SystemSetupCmds = n.SystemSetupCmds
+	}
+
+	productionBuild = productionBuild.
+		WithVersion(baseImageRefParts[0], version, false)
+
+	if n.NpmrcToken != nil {
+		productionBuild = productionBuild.WithNpmrcTokenEnv(n.NpmrcTokenName, n.NpmrcToken)
+	}
+
+	if n.NpmrcFile != nil {
+		productionBuild = productionBuild.WithNpmrcTokenFile(n.NpmrcFile)
+	}
+
+	productionBuild.Ctr = n.
+		withRegistryAuths(productionBuild.Ctr).
+		WithDirectory(workdir, src).
+		WithWorkdir(workdir)
+
+	if n.YarnBerry {
+		// The cache has to be in the project to be part of the image with Plug'n'Play
+		productionBuild.Ctr = productionBuild.Ctr.WithEnvVariable("YARN_ENABLE_GLOBAL_CACHE", "false")
 	}
 
 	productionBuild = productionBuild.
 		SetupSystem(nil).
-		Production().
-		WithPackageManager(n.PkgMgr, true, n.PkgMgrVersion).
-		Install()
+		Production()
 
-	for _, registry := range registries {
-		eg.Go(func() error {
-			ref := fmt.Sprintf("%s/%s:%s", registry, n.Name, n.Version)
-			if isTtl {
-				ref = fmt.Sprintf("%s/%s:%s", ttlRegistry, uuid.New().String(), ttl)
+	// The production dependencies may have to be compiled, the toolchain isn't copied in the runtime container
+	if n.NativeAddon {
+		productionBuild = productionBuild.WithNativeToolchain()
+	}
+
+	if n.Corepack {
+		productionBuild = productionBuild.WithCorepack(n.PkgMgr+"@"+n.PkgMgrVersion, true)
+	} else {
+		productionBuild = productionBuild.WithPackageManager(n.PkgMgr, true, n.PkgMgrVersion)
+	}
+
+	return productionBuild.Install(ctx, frozenLockfile)
+}
+
+// checkRuntimePlatforms ensure a custom runtime container, which has a single platform, matches the platform of the image
+func (n *Node) checkRuntimePlatforms(ctx context.Context, platforms []dagger.Platform) error {
+	if n.RuntimeImage != "custom" {
+		return nil
+	}
+
+	if len(platforms) > 1 {
+		return fmt.Errorf("a custom runtime image has a single platform, it can't be used to build the platforms %v", platforms)
+	}
+
+	runtimePlatform, err := n.RuntimeCtr.Platform(ctx)
+	if err != nil {
+		return err
+	}
+
+	if runtimePlatform != platforms[0] {
+		return fmt.Errorf("the custom runtime image is built for '%s' but the image is built for '%s'", runtimePlatform, platforms[0])
+	}
+
+	return nil
+}
+
+// productionImageVersion return the tag of the node image installing the production dependencies, its libc has to be the one of the runtime image for the native modules
+func (n *Node) productionImageVersion(ctx context.Context, version string) (string, error) {
+	glibcVersion := strings.TrimSuffix(version, "-alpine")
+
+	switch n.RuntimeImage {
+	case "":
+		return version, nil
+	case "custom":
+		// The musl dynamic loader is only in the alpine like images, the custom container has the single platform of the image
+		muslLoaders, err := n.RuntimeCtr.Rootfs().Glob(ctx, "lib/ld-musl-*")
+		if err != nil {
+			return "", err
+		}
+
+		if len(muslLoaders) > 0 {
+			return glibcVersion + "-alpine", nil
+		}
+
+		return glibcVersion, nil
+	default:
+		// The slim and distroless runtime images are debian based
+		return glibcVersion, nil
+	}
+}
+
+// runtimeContainer return the final image, the application built in the production container is copied in the runtime image if any
+func (n *Node) runtimeContainer(platform dagger.Platform, production *Node) *dagger.Container {
+	// The registry tokens are only used to install the dependencies
+	ctr := n.withoutRegistryAuths(production.Ctr)
+
+	if n.RuntimeImage == "" && production.NativeAddon {
+		// The production container has the native toolchain, the application is copied in a clean base image
+		ctr = dag.
+			Container(dagger.ContainerOpts{Platform: platform}).
+			From(production.BaseImageRef)
+
+		for _, cmd := range production.SystemSetupCmds {
+			ctr = ctr.WithExec(cmd)
+		}
+
+		ctr = ctr.
+			WithEnvVariable("NODE_ENV", "production").
+			WithDirectory(workdir, production.Ctr.Directory(workdir)).
+			WithWorkdir(workdir)
+	}
+
+	if n.RuntimeImage != "" {
+		version := strings.TrimSuffix(strings.Split(production.BaseImageRef, ":")[1], "-alpine")
+
+		switch n.RuntimeImage {
+		case "slim":
+			ctr = dag.
+				Container(dagger.ContainerOpts{Platform: platform}).
+				From("node:" + version + "-slim")
+		case "distroless":
+			ctr = dag.
+				Container(dagger.ContainerOpts{Platform: platform}).
+				From("gcr.io/distroless/nodejs" + strings.Split(version, ".")[0] + "-debian12")
+		case "custom":
+			ctr = n.RuntimeCtr
+		}
+
+		if len(n.RuntimeSetupCmds) > 0 {
+			ctr = ctr.WithUser("root")
+			for _, cmd := range n.RuntimeSetupCmds {
+				ctr = ctr.WithExec(cmd)
 			}
+		}
 
-			ref, err := productionBuild.Ctr.Publish(ctx, ref)
-			result <- ref
+		ctr = ctr.
+			WithEnvVariable("NODE_ENV", "production").
+			WithDirectory(workdir, production.Ctr.Directory(workdir), dagger.ContainerWithDirectoryOpts{
+				Owner: n.RuntimeUser,
+			}).
+			WithWorkdir(workdir).
+			WithUser(n.RuntimeUser)
+	}
 
-			return err
-		})
+	if n.RuntimeEntrypoint != nil {
+		ctr = ctr.WithEntrypoint(n.RuntimeEntrypoint)
 	}
 
-	go func() {
-		err = eg.Wait()
-		close(result)
-	}()
+	if n.RuntimeCmd != nil {
+		ctr = ctr.WithDefaultArgs(n.RuntimeCmd)
+	}
 
-	for res := range result {
-		fullyQualifiedImageNames = append(fullyQualifiedImageNames, res)
+	for _, port := range n.RuntimeExposedPorts {
+		ctr = ctr.WithExposedPort(port)
 	}
 
-	return fullyQualifiedImageNames, err
+	return ctr
 }
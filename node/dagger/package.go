@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"main/internal/dagger"
+)
+
+const (
+	cosignImage = "gcr.io/projectsigstore/cosign:v2.5.0"
+)
+
+// The commands writing the package tarball in the package directory
+var packCommands = map[string][]string{
+	"npm":  {"npm", "pack", "--pack-destination", packageDir},
+	"pnpm": {"pnpm", "pack", "--pack-destination", packageDir},
+	"bun":  {"bun", "pm", "pack", "--destination", packageDir},
+	"yarn": {"yarn", "pack", "--filename", packageDir + "/package.tgz"},
+}
+
+// The package tarball with its checksum, signature and sigstore bundle
+type PackageArtifacts struct {
+	// The package tarball
+	Tarball *dagger.File
+	// The sha256 checksum of the tarball
+	Checksum *dagger.File
+	// The cosign signature of the tarball, only when the package is signed
+	Signature *dagger.File
+	// The sigstore bundle of the signature, only when the package is signed
+	Bundle *dagger.File
+}
+
+// Return the package tarball packed by 'publish' with its checksum, and its signature and sigstore bundle when signed
+func (n *Node) PackageArtifacts(ctx context.Context) (*PackageArtifacts, error) {
+	tarballs, err := n.Ctr.Directory(packageDir).Glob(ctx, "*.tgz")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tarballs) == 0 {
+		return nil, fmt.Errorf("no package tarball found, the package has to be published with 'publish'")
+	}
+
+	tarball := packageDir + "/" + tarballs[0]
+	artifacts := &PackageArtifacts{
+		Tarball:  n.Ctr.File(tarball),
+		Checksum: n.Ctr.File(tarball + ".sha256"),
+	}
+
+	signatures, err := n.Ctr.Directory(packageDir).Glob(ctx, "*.sig")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(signatures) > 0 {
+		artifacts.Signature = n.Ctr.File(tarball + ".sig")
+		artifacts.Bundle = n.Ctr.File(tarball + ".sigstore.json")
+	}
+
+	return artifacts, nil
+}
+
+// pack write the package tarball and its sha256 checksum in the package directory and return the name of the tarball
+func (n *Node) pack(ctx context.Context) (string, error) {
+	packCmd, ok := packCommands[n.PkgMgr]
+	if !ok {
+		packCmd = packCommands["npm"]
+	}
+
+	if n.YarnBerry {
+		packCmd = []string{"yarn", "pack", "--out", packageDir + "/package.tgz"}
+	}
+
+	n.Ctr = n.
+		Ctr.
+		WithExec([]string{"mkdir", "-p", packageDir}).
+		WithExec(packCmd).
+		WithExec([]string{"sh", "-c", "cd " + packageDir + " && for tarball in *.tgz; do sha256sum \"$tarball\" > \"$tarball.sha256\"; done"})
+
+	tarballs, err := n.Ctr.Directory(packageDir).Glob(ctx, "*.tgz")
+	if err != nil {
+		return "", err
+	}
+
+	if len(tarballs) != 1 {
+		return "", fmt.Errorf("expected one package tarball, found %d", len(tarballs))
+	}
+
+	return tarballs[0], nil
+}
+
+// withPackageSignature sign the tarball with cosign and add the signature and the sigstore bundle next to it
+func (n *Node) withPackageSignature(
+	tarball string,
+	oidcToken *dagger.Secret,
+	signingKey *dagger.Secret,
+	signingKeyPassword *dagger.Secret,
+) *dagger.Container {
+	signCmd := []string{
+		"sign-blob",
+		"--yes",
+		"--new-bundle-format",
+		"--output-signature", "/tmp/" + tarball + ".sig",
+		"--bundle", "/tmp/" + tarball + ".sigstore.json",
+	}
+
+	signer := dag.
+		Container().
+		From(cosignImage).
+		WithMountedFile("/tmp/"+tarball, n.Ctr.File(packageDir+"/"+tarball))
+
+	if signingKey != nil {
+		signCmd = append(signCmd, "--key", "env://COSIGN_PRIVATE_KEY")
+		signer = signer.WithSecretVariable("COSIGN_PRIVATE_KEY", signingKey)
+
+		if signingKeyPassword != nil {
+			signer = signer.WithSecretVariable("COSIGN_PASSWORD", signingKeyPassword)
+		} else {
+			signer = signer.WithEnvVariable("COSIGN_PASSWORD", "")
+		}
+	} else {
+		// Keyless signing with the OIDC token
+		signer = signer.WithSecretVariable("SIGSTORE_ID_TOKEN", oidcToken)
+	}
+
+	signer = signer.WithExec(append(signCmd, "/tmp/"+tarball), dagger.ContainerWithExecOpts{
+		UseEntrypoint: true,
+	})
+
+	return n.
+		Ctr.
+		WithFile(packageDir+"/"+tarball+".sig", signer.File("/tmp/"+tarball+".sig")).
+		WithFile(packageDir+"/"+tarball+".sigstore.json", signer.File("/tmp/"+tarball+".sigstore.json"))
+}
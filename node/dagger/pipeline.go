@@ -2,16 +2,18 @@ package main
 
 import (
 	"context"
-	"strings"
+	"fmt"
+	"main/internal/dagger"
+	"slices"
 )
 
 // Execute the whole pipeline in general used with the function 'with-auto-setup'
 func (n *Node) Pipeline(
 	ctx context.Context,
-	// Define hooks to execute before all
+	// Define hooks to execute after the install, same as the 'after-install' hook point
 	// +optional
 	preHooks [][]string,
-	// Define hooks to execute after tests and before build
+	// Define hooks to execute after tests and before build, same as the 'before-build' hook point
 	// +optional
 	postHooks [][]string,
 	// Indicate if the artifact is an oci build or not
@@ -28,6 +30,27 @@ func (n *Node) Pipeline(
 	// Indicate if the package is publishing as development version
 	// +optional
 	packageDevTag string,
+	// Publish the package with a provenance attestation
+	// +optional
+	packageProvenance bool,
+	// An OIDC token used for the provenance outside of GitHub Actions (e.g. GitLab) and the keyless signing of the package
+	// +optional
+	oidcToken *dagger.Secret,
+	// The GitHub Actions 'ACTIONS_ID_TOKEN_REQUEST_URL' used to request the OIDC token of the provenance
+	// +optional
+	oidcRequestUrl *dagger.Secret,
+	// The GitHub Actions 'ACTIONS_ID_TOKEN_REQUEST_TOKEN' used to request the OIDC token of the provenance
+	// +optional
+	oidcRequestToken *dagger.Secret,
+	// Sign the package tarball with cosign
+	// +optional
+	signPackage bool,
+	// A cosign private key to sign the package tarball, keyless with the OIDC token by default
+	// +optional
+	signingKey *dagger.Secret,
+	// The password of the cosign private key
+	// +optional
+	signingKeyPassword *dagger.Secret,
 	// Define path to file to fetch from the build container
 	// +optional
 	fileContainerArtifacts []string,
@@ -45,50 +68,508 @@ func (n *Node) Pipeline(
 	// +optional
 	// +default="60m"
 	ttl string,
-) (string, error) {
-	pipeline := n.Install()
+	// Run the tests with coverage and fail when the line coverage percentage is below this threshold
+	// +optional
+	coverageLineThreshold float64,
+	// Run the tests with coverage and fail when the branch coverage percentage is below this threshold
+	// +optional
+	coverageBranchThreshold float64,
+	// Define the platforms to build the image for (e.g. linux/amd64, linux/arm64)
+	// +optional
+	ociPlatforms []dagger.Platform,
+	// Build the image from the detected Dockerfile instead of the built-in production image
+	// +optional
+	useDockerfile bool,
+	// Build arguments to pass to the Dockerfile build (KEY=VALUE)
+	// +optional
+	dockerBuildArgs []string,
+	// The target stage of the Dockerfile to build
+	// +optional
+	dockerTarget string,
+	// Secrets to expose to the Dockerfile build
+	// +optional
+	dockerSecrets []*dagger.Secret,
+	// Audit the dependencies after the install and fail when a finding has at least this severity (info | low | moderate | high | critical)
+	// +optional
+	auditLevel string,
+	// A file with the accepted advisory ids for the audit (one per line)
+	// +optional
+	auditAllowlist *dagger.File,
+	// Install exactly the lockfile and fail when it is out of sync with the package.json
+	// +optional
+	// +default=true
+	frozenLockfile bool,
+	// Run lint, test and build only for the workspaces affected by these changed files and their dependents
+	// +optional
+	changedFiles []string,
+	// Run lint, test and build only for the workspaces affected by the changes since this git ref and their dependents, the source has to contain the '.git' folder
+	// +optional
+	baseRef string,
+	// Publish each public workspace package and build an image for each workspace with a Dockerfile instead of the root package, the provenance and the signature are not supported
+	// +optional
+	perWorkspace bool,
+	// The workspaces without Dockerfile to build with the production image when building per workspace
+	// +optional
+	deployableWorkspaces []string,
+	// Return the result with the failed stage instead of an error when a stage fails
+	// +optional
+	ignoreFailure bool,
+	// Run the lint and the test stages in parallel containers, their outputs are merged before the build
+	// +optional
+	parallel bool,
+) (*PipelineResult, error) {
+	if useDockerfile && !perWorkspace && n.Dockerfile == "" {
+		return nil, fmt.Errorf("the image has to be built from a Dockerfile but no Dockerfile or Containerfile was detected in the source")
+	}
 
-	// TODO(how we handle capture output later define if we keep this function or change the return type for Node then user decide what to do)
+	// Fail before running the stages, the publish stage would reject it
+	if signPackage && n.YarnBerry {
+		return nil, fmt.Errorf("signing the package is not supported by yarn berry which publishes the project folder instead of the signed tarball")
+	}
 
-	for _, hook := range preHooks {
-		pipeline = pipeline.Run(hook, false)
+	// The workspaces are published with their own package manager command, without provenance nor signature
+	if perWorkspace && (packageProvenance || signPackage) {
+		return nil, fmt.Errorf("the provenance and the signature of the packages are not supported when publishing per workspace")
+	}
+
+	result := &PipelineResult{}
+
+	// The arguments take precedence over the config file
+	if preHooks == nil {
+		preHooks = n.PreHooks
 	}
 
-	if n.DetectLint {
-		pipeline = pipeline.Lint(false)
+	if postHooks == nil {
+		postHooks = n.PostHooks
 	}
 
-	if n.DetectTest {
-		pipeline = pipeline.Test(false)
+	if fileContainerArtifacts == nil {
+		fileContainerArtifacts = n.FileContainerArtifacts
+	}
+
+	if directoryContainerArtifacts == nil {
+		directoryContainerArtifacts = n.DirectoryContainerArtifacts
+	}
+
+	if ociRegistries == nil {
+		ociRegistries = n.OciRegistries
+	}
+
+	pipeline, err := n.runPipeline(
+		ctx,
+		result,
+		preHooks,
+		postHooks,
+		isOci,
+		dryRun,
+		packageAccess,
+		packageDevTag,
+		packageProvenance,
+		oidcToken,
+		oidcRequestUrl,
+		oidcRequestToken,
+		signPackage,
+		signingKey,
+		signingKeyPassword,
+		fileContainerArtifacts,
+		directoryContainerArtifacts,
+		ociRegistries,
+		ttlRegistry,
+		ttl,
+		coverageLineThreshold,
+		coverageBranchThreshold,
+		ociPlatforms,
+		useDockerfile,
+		dockerBuildArgs,
+		dockerTarget,
+		dockerSecrets,
+		auditLevel,
+		auditAllowlist,
+		frozenLockfile,
+		changedFiles,
+		baseRef,
+		perWorkspace,
+		deployableWorkspaces,
+		parallel,
+	)
+	if err != nil && !ignoreFailure {
+		return nil, err
+	}
+
+	if pipeline != nil {
+		result.Container = pipeline.Ctr
+	}
+
+	return result, nil
+}
+
+// runPipeline run the stages of the pipeline and record them in the result, the last successful node is returned with the error of the failed stage
+func (n *Node) runPipeline(
+	ctx context.Context,
+	result *PipelineResult,
+	preHooks [][]string,
+	postHooks [][]string,
+	isOci bool,
+	dryRun bool,
+	packageAccess string,
+	packageDevTag string,
+	packageProvenance bool,
+	oidcToken *dagger.Secret,
+	oidcRequestUrl *dagger.Secret,
+	oidcRequestToken *dagger.Secret,
+	signPackage bool,
+	signingKey *dagger.Secret,
+	signingKeyPassword *dagger.Secret,
+	fileContainerArtifacts []string,
+	directoryContainerArtifacts []string,
+	ociRegistries []string,
+	ttlRegistry string,
+	ttl string,
+	coverageLineThreshold float64,
+	coverageBranchThreshold float64,
+	ociPlatforms []dagger.Platform,
+	useDockerfile bool,
+	dockerBuildArgs []string,
+	dockerTarget string,
+	dockerSecrets []*dagger.Secret,
+	auditLevel string,
+	auditAllowlist *dagger.File,
+	frozenLockfile bool,
+	changedFiles []string,
+	baseRef string,
+	perWorkspace bool,
+	deployableWorkspaces []string,
+	parallel bool,
+) (*Node, error) {
+	hooks := n.Hooks
+	for _, hook := range preHooks {
+		hooks = append(hooks, PipelineHook{Point: "after-install", Command: hook})
 	}
 
-	// TODO(Move it at the end)
 	for _, hook := range postHooks {
-		pipeline = pipeline.Run(hook, false)
+		hooks = append(hooks, PipelineHook{Point: "before-build", Command: hook})
 	}
 
-	pipeline = pipeline.Build(false)
+	pipeline, err := result.runHooks(ctx, n, hooks, "before-install")
+	if err != nil {
+		return nil, err
+	}
 
-	if n.DetectPackage {
-		return pipeline.
-			Publish(packageAccess, packageDevTag, dryRun).
-			Do(ctx)
+	installed, err := result.runStage(ctx, "install", func() (*Node, []string, error) {
+		install, err := pipeline.fork().Install(ctx, frozenLockfile)
+		return install, nil, err
+	})
+	if err != nil {
+		return pipeline, err
 	}
 
-	if n.DetectOci || isOci {
-		refs, err := pipeline.
-			OciBuild(
-				ctx,
-				fileContainerArtifacts,
-				directoryContainerArtifacts,
-				ociRegistries,
-				dryRun,
-				ttlRegistry,
-				ttl,
-			)
+	pipeline = installed
+
+	if auditLevel != "" {
+		_, err := result.runStage(ctx, "audit", func() (*Node, []string, error) {
+			report, err := pipeline.Audit(ctx, auditLevel, auditAllowlist)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			var findings []string
+			for _, finding := range report.Findings {
+				findings = append(findings, finding.ID)
+			}
+
+			return nil, findings, nil
+		})
+		if err != nil {
+			return pipeline, err
+		}
+	}
+
+	pipeline, err = result.runHooks(ctx, pipeline, hooks, "after-install")
+	if err != nil {
+		return pipeline, err
+	}
+
+	// Nothing to lint, test, build or publish when no workspace is affected by the changes, the hooks still run
+	unaffected := false
+	var affectedPackages []workspacePackage
+	if n.Src != nil && (changedFiles != nil || baseRef != "") {
+		analyzer := n.nodeAnalyzer(n.Src)
+
+		packages, err := analyzer.GetWorkspacePackages(ctx)
+		if err != nil {
+			return pipeline, err
+		}
+
+		// The affected workspaces only matter in a monorepo
+		if len(packages) > 0 {
+			affected, err := analyzer.GetAffectedWorkspaces(ctx, dagger.AutodetectionNodeAnalyzerGetAffectedWorkspacesOpts{
+				ChangedFiles: changedFiles,
+				BaseRef:      baseRef,
+			})
+			if err != nil {
+				return pipeline, err
+			}
+
+			unaffected = len(affected) == 0
+			pipeline.Workspaces = affected
+
+			if !unaffected {
+				affectedPackages, err = pipeline.selectedWorkspacePackages(ctx, affected)
+				if err != nil {
+					return pipeline, err
+				}
+			}
+		}
+	}
+
+	// With affected workspaces, a stage only runs in the ones defining its script and is skipped when none does
+	scriptStage := func(pipeline *Node, script string) (*Node, bool) {
+		stage := pipeline.fork()
+		if affectedPackages == nil {
+			return stage, true
+		}
+
+		stage.Workspaces = workspacesWithScript(affectedPackages, script)
+		return stage, len(stage.Workspaces) > 0
+	}
+
+	lint := func(result *PipelineResult, pipeline *Node) (*Node, error) {
+		pipeline, err := result.runHooks(ctx, pipeline, hooks, "before-lint")
+		if err != nil {
+			return pipeline, err
+		}
+
+		stage, hasScript := scriptStage(pipeline, "lint")
+		if !n.DetectLint || unaffected || !hasScript || slices.Contains(n.SkipStages, "lint") {
+			result.skip("lint")
+		} else {
+			next, err := result.runStage(ctx, "lint", func() (*Node, []string, error) {
+				return stage.Lint(false), nil, nil
+			})
+			if err != nil {
+				return pipeline, err
+			}
+
+			next.Workspaces = pipeline.Workspaces
+			pipeline = next
+		}
+
+		return result.runHooks(ctx, pipeline, hooks, "after-lint")
+	}
+
+	test := func(result *PipelineResult, pipeline *Node) (*Node, error) {
+		pipeline, err := result.runHooks(ctx, pipeline, hooks, "before-test")
+		if err != nil {
+			return pipeline, err
+		}
+
+		stage, hasScript := scriptStage(pipeline, "test")
+		if !n.DetectTest || unaffected || !hasScript || slices.Contains(n.SkipStages, "test") {
+			result.skip("test")
+		} else {
+			next, err := result.runStage(ctx, "test", func() (*Node, []string, error) {
+				if coverageLineThreshold > 0 || coverageBranchThreshold > 0 {
+					covered, err := stage.withCoverage(ctx, coverageLineThreshold, coverageBranchThreshold)
+					return covered, nil, err
+				}
+
+				tested, err := stage.Test(ctx, false, false)
+				return tested, nil, err
+			})
+			if err != nil {
+				return pipeline, err
+			}
+
+			next.Workspaces = pipeline.Workspaces
+			pipeline = next
+		}
+
+		return result.runHooks(ctx, pipeline, hooks, "after-test")
+	}
+
+	if parallel {
+		pipeline, err = result.runParallel(pipeline, lint, test)
+	} else {
+		pipeline, err = lint(result, pipeline)
+		if err == nil {
+			pipeline, err = test(result, pipeline)
+		}
+	}
+	if err != nil {
+		return pipeline, err
+	}
+
+	pipeline, err = result.runHooks(ctx, pipeline, hooks, "before-build")
+	if err != nil {
+		return pipeline, err
+	}
+
+	stage, hasScript := scriptStage(pipeline, "build")
+	if unaffected || !hasScript || slices.Contains(n.SkipStages, "build") {
+		result.skip("build")
+	} else {
+		next, err := result.runStage(ctx, "build", func() (*Node, []string, error) {
+			return stage.Build(false), nil, nil
+		})
+		if err != nil {
+			return pipeline, err
+		}
+
+		next.Workspaces = pipeline.Workspaces
+		pipeline = next
+	}
+
+	pipeline, err = result.runHooks(ctx, pipeline, hooks, "after-build")
+	if err != nil {
+		return pipeline, err
+	}
+
+	pipeline, err = result.runHooks(ctx, pipeline, hooks, "before-publish")
+	if err != nil {
+		return pipeline, err
+	}
+
+	// The publish stage publishes the package or the images
+	publish := func() (*Node, error) {
+		if perWorkspace {
+			if unaffected || slices.Contains(n.SkipStages, "publish") {
+				result.skip("publish")
+			} else {
+				_, err := result.runStage(ctx, "publish", func() (*Node, []string, error) {
+					artifacts, err := pipeline.PublishWorkspaces(ctx, nil, packageAccess, packageDevTag, dryRun)
+					return nil, workspaceArtifactRefs(artifacts), err
+				})
+				if err != nil {
+					return pipeline, err
+				}
+			}
+
+			if unaffected || slices.Contains(n.SkipStages, "oci") {
+				result.skip("oci")
+				return pipeline, nil
+			}
+
+			_, err := result.runStage(ctx, "oci", func() (*Node, []string, error) {
+				artifacts, err := pipeline.WorkspaceOciBuild(
+					ctx,
+					ociRegistries,
+					deployableWorkspaces,
+					nil,
+					dockerBuildArgs,
+					dockerTarget,
+					dockerSecrets,
+					dryRun,
+					ttlRegistry,
+					ttl,
+					ociPlatforms,
+					frozenLockfile,
+				)
+				return nil, workspaceArtifactRefs(artifacts), err
+			})
+
+			return pipeline, err
+		}
+
+		if n.DetectPackage && (unaffected || slices.Contains(n.SkipStages, "publish")) {
+			result.skip("publish")
+			return pipeline, nil
+		}
+
+		if n.DetectPackage {
+			published, err := result.runStage(ctx, "publish", func() (*Node, []string, error) {
+				publish, err := pipeline.fork().Publish(
+					ctx,
+					packageAccess,
+					packageDevTag,
+					dryRun,
+					packageProvenance,
+					oidcToken,
+					oidcRequestUrl,
+					oidcRequestToken,
+					signPackage,
+					signingKey,
+					signingKeyPassword,
+				)
+
+				return publish, []string{n.Name + "@" + n.Version}, err
+			})
+			if err != nil {
+				return pipeline, err
+			}
+
+			result.Package, err = published.PackageArtifacts(ctx)
+			if err != nil {
+				return published, err
+			}
+
+			return published, nil
+		}
+
+		if (n.DetectOci || isOci || useDockerfile) && (unaffected || slices.Contains(n.SkipStages, "oci")) {
+			result.skip("oci")
+			return pipeline, nil
+		}
+
+		if useDockerfile && n.Dockerfile != "" {
+			_, err := result.runStage(ctx, "oci", func() (*Node, []string, error) {
+				refs, err := pipeline.
+					DockerBuild(
+						ctx,
+						ociRegistries,
+						n.Dockerfile,
+						dockerBuildArgs,
+						dockerTarget,
+						dockerSecrets,
+						dryRun,
+						ttlRegistry,
+						ttl,
+						ociPlatforms,
+					)
+
+				return nil, refs, err
+			})
+
+			return pipeline, err
+		}
+
+		if n.DetectOci || isOci {
+			_, err := result.runStage(ctx, "oci", func() (*Node, []string, error) {
+				refs, err := pipeline.
+					OciBuild(
+						ctx,
+						fileContainerArtifacts,
+						directoryContainerArtifacts,
+						ociRegistries,
+						dryRun,
+						ttlRegistry,
+						ttl,
+						ociPlatforms,
+						frozenLockfile,
+					)
+
+				return nil, refs, err
+			})
+
+			return pipeline, err
+		}
+
+		return pipeline, nil
+	}
+
+	pipeline, err = publish()
+	if err != nil {
+		return pipeline, err
+	}
+
+	return result.runHooks(ctx, pipeline, hooks, "after-publish")
+}
 
-		return strings.Join(refs, "\n"), err
+// workspaceArtifactRefs format the workspace artifacts as 'workspace ref'
+func workspaceArtifactRefs(artifacts []WorkspaceArtifact) []string {
+	var refs []string
+	for _, artifact := range artifacts {
+		refs = append(refs, artifact.Workspace+" "+artifact.Ref)
 	}
 
-	return pipeline.Do(ctx)
+	return refs
 }
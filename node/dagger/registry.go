@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"main/internal/dagger"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	registryTokenEnvPrefix = "NODE_AUTH_TOKEN_"
+	userNpmrc              = "/root/.npmrc"
+	userYarnrc             = "/root/.yarnrc.yml"
+)
+
+// The authentication of a npm registry, for a scope or as default registry
+type RegistryAuth struct {
+	// The scope using the registry (e.g. @my-org), empty for the default registry
+	Scope string
+	// The url of the registry
+	RegistryURL string
+	// The token of the registry
+	Token *dagger.Secret
+}
+
+// Authenticate a npm registry for a scope or as default registry (GitHub Packages, Artifactory, Verdaccio ...), it can be called for each registry.
+// A user '.npmrc' and '.yarnrc.yml' referring to the tokens as environment variables are mounted, neither the config nor the tokens are in the image layers.
+func (n *Node) WithRegistryAuth(
+	// The scope using the registry (e.g. @my-org), the registry is the default one when empty
+	// +optional
+	scope string,
+	// The url of the registry (e.g. https://npm.pkg.github.com, https://my-org.jfrog.io/artifactory/api/npm/npm-local/)
+	registryUrl string,
+	// The token of the registry
+	token *dagger.Secret,
+) (*Node, error) {
+	registry, err := url.Parse(registryUrl)
+	if err != nil || (registry.Scheme != "https" && registry.Scheme != "http") || registry.Host == "" {
+		return nil, fmt.Errorf("invalid registry url '%s', it has to be an http(s) url", registryUrl)
+	}
+
+	if scope != "" && !strings.HasPrefix(scope, "@") {
+		scope = "@" + scope
+	}
+
+	auth := RegistryAuth{
+		Scope:       scope,
+		RegistryURL: registry.Scheme + "://" + registry.Host + strings.TrimSuffix(registry.Path, "/") + "/",
+		Token:       token,
+	}
+
+	// A scope has only one registry, the last one wins
+	var auths []RegistryAuth
+	for _, registryAuth := range n.RegistryAuths {
+		if registryAuth.Scope != scope {
+			auths = append(auths, registryAuth)
+		}
+	}
+	n.RegistryAuths = append(auths, auth)
+
+	n.Ctr = n.withRegistryAuths(n.Ctr)
+
+	return n, nil
+}
+
+// withRegistryAuths mount the user registry configs and set the tokens as secret environment variables in the container
+func (n *Node) withRegistryAuths(ctr *dagger.Container) *dagger.Container {
+	if len(n.RegistryAuths) == 0 {
+		return ctr
+	}
+
+	var npmrc, yarnrc, yarnScopes strings.Builder
+	for idx, auth := range n.RegistryAuths {
+		tokenEnv := registryTokenEnvPrefix + strconv.Itoa(idx)
+		authKey := strings.TrimPrefix(strings.TrimPrefix(auth.RegistryURL, "https:"), "http:")
+
+		ctr = ctr.WithSecretVariable(tokenEnv, auth.Token)
+
+		if auth.Scope == "" {
+			fmt.Fprintf(&npmrc, "registry=%s\n", auth.RegistryURL)
+			fmt.Fprintf(&yarnrc, "npmRegistryServer: %q\nnpmAuthToken: \"${%s}\"\n", auth.RegistryURL, tokenEnv)
+		} else {
+			fmt.Fprintf(&npmrc, "%s:registry=%s\n", auth.Scope, auth.RegistryURL)
+			fmt.Fprintf(&yarnScopes, "  %s:\n    npmRegistryServer: %q\n    npmAuthToken: \"${%s}\"\n", strings.TrimPrefix(auth.Scope, "@"), auth.RegistryURL, tokenEnv)
+		}
+		fmt.Fprintf(&npmrc, "%s:_authToken=${%s}\n", authKey, tokenEnv)
+	}
+
+	if yarnScopes.Len() > 0 {
+		yarnrc.WriteString("npmScopes:\n" + yarnScopes.String())
+	}
+
+	// The configs only refer to the environment variables, they are mounted to stay out of the layers
+	configs := dag.
+		Directory().
+		WithNewFile(".npmrc", npmrc.String()).
+		WithNewFile(".yarnrc.yml", yarnrc.String())
+
+	return ctr.
+		WithMountedFile(userNpmrc, configs.File(".npmrc")).
+		WithMountedFile(userYarnrc, configs.File(".yarnrc.yml"))
+}
+
+// withoutRegistryAuths remove the registry configs and tokens from the container
+func (n *Node) withoutRegistryAuths(ctr *dagger.Container) *dagger.Container {
+	if len(n.RegistryAuths) == 0 {
+		return ctr
+	}
+
+	for idx := range n.RegistryAuths {
+		ctr = ctr.WithoutSecretVariable(registryTokenEnvPrefix + strconv.Itoa(idx))
+	}
+
+	return ctr.
+		WithoutMount(userNpmrc).
+		WithoutMount(userYarnrc)
+}
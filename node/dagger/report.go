@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"main/internal/dagger"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	reportsDir       = "/outputs/reports"
+	mergedReportName = "junit.xml"
+	// Where the official node images install global packages
+	globalNodeModules = "/usr/local/lib/node_modules"
+	// The wrapper running a command with the junit reporter of the node test runner
+	nodeTestReportWrapper = "/usr/local/bin/node-test-report"
+)
+
+// nodeTestReportScript append the reporters to the existing NODE_OPTIONS, the report path is the first argument
+const nodeTestReportScript = `#!/bin/sh
+report="$1"
+shift
+NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--test-reporter=spec --test-reporter-destination=stdout --test-reporter=junit --test-reporter-destination=$report" exec "$@"
+`
+
+var xmlHeaderRe = regexp.MustCompile(`^\s*<\?xml[^>]*\?>`)
+
+// A summary of the junit reports written by the test runner
+type TestSummary struct {
+	// The number of tests which passed
+	Passed int
+	// The number of tests which failed or errored
+	Failed int
+	// The number of tests which were skipped
+	Skipped int
+	// The name of the failing tests
+	FailingTests []string
+}
+
+// TestFailureError is returned when the tests fail, the failing tests are read from the junit reports when they are enabled
+type TestFailureError struct {
+	ExitCode     int
+	FailingTests []string
+	Output       string
+}
+
+func (e *TestFailureError) Error() string {
+	if len(e.FailingTests) == 0 {
+		return fmt.Sprintf("the tests failed with the exit code %d:\n%s", e.ExitCode, e.Output)
+	}
+
+	return fmt.Sprintf(
+		"the tests failed with the exit code %d, failing tests:\n  %s\n%s",
+		e.ExitCode,
+		strings.Join(e.FailingTests, "\n  "),
+		e.Output,
+	)
+}
+
+// isTestFailure indicate the tests ran and failed, unlike an error preventing them to run
+func isTestFailure(err error) bool {
+	var failure *TestFailureError
+	return errors.As(err, &failure)
+}
+
+type junitRoot struct {
+	XMLName xml.Name
+	Inner   string `xml:",innerxml"`
+}
+
+type junitTestSuites struct {
+	Suites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name   string           `xml:"name,attr"`
+	Suites []junitTestSuite `xml:"testsuite"`
+	Cases  []junitTestCase  `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string    `xml:"classname,attr"`
+	Name      string    `xml:"name,attr"`
+	Failure   *struct{} `xml:"failure"`
+	Error     *struct{} `xml:"error"`
+	Skipped   *struct{} `xml:"skipped"`
+}
+
+// Configure the test runner to write a junit report in /outputs/reports when running tests
+func (n *Node) WithTestReport(
+	// The test runner used by the test scripts (jest | vitest | mocha | node)
+	runner string,
+) (*Node, error) {
+	n.TestRunner = runner
+	n.TestReportEnabled = true
+	n.Ctr = n.Ctr.WithExec([]string{"mkdir", "-p", reportsDir})
+
+	switch runner {
+	case "jest":
+		// jest needs a reporter package, it is installed globally to avoid touching the project dependencies
+		n.Ctr = n.Ctr.WithExec([]string{"npm", "install", "-g", "jest-junit"})
+	case "node":
+		n.Ctr = n.Ctr.WithNewFile(nodeTestReportWrapper, nodeTestReportScript, dagger.ContainerWithNewFileOpts{
+			Permissions: 0o755,
+		})
+	case "vitest", "mocha":
+	default:
+		return nil, fmt.Errorf("unsupported test runner '%s' for the test report (jest | vitest | mocha | node)", runner)
+	}
+
+	return n, nil
+}
+
+// testCommand return the command running the script with the arguments and, when enabled, the options making the test runner write a junit report,
+// the environment variables of the reporters are only set for this command
+func (n *Node) testCommand(command []string, args []string) []string {
+	if !n.TestReportEnabled {
+		return n.scriptCommand(n.withScriptArgs(command, args))
+	}
+
+	reportPath := reportsDir + "/" + n.TestRunner + "-" + uuid.New().String() + ".xml"
+
+	switch n.TestRunner {
+	case "jest":
+		return append(
+			[]string{"env", "JEST_JUNIT_OUTPUT_FILE=" + reportPath},
+			n.scriptCommand(n.withScriptArgs(command, append([]string{"--reporters=default", "--reporters=" + globalNodeModules + "/jest-junit"}, args...)))...,
+		)
+	case "vitest":
+		return n.scriptCommand(n.withScriptArgs(command, append([]string{"--reporter=default", "--reporter=junit", "--outputFile.junit=" + reportPath}, args...)))
+	case "mocha":
+		return n.scriptCommand(n.withScriptArgs(command, append([]string{"--reporter=xunit", "--reporter-option=output=" + reportPath}, args...)))
+	case "node":
+		// The node test runner options have to be set before the test files, they are added to the NODE_OPTIONS of the command by a wrapper
+		return append([]string{nodeTestReportWrapper, reportPath}, n.scriptCommand(n.withScriptArgs(command, args))...)
+	}
+
+	return n.scriptCommand(n.withScriptArgs(command, args))
+}
+
+// Return the junit reports written by the test runner merged in a single file
+func (n *Node) TestReport(ctx context.Context) (*dagger.File, error) {
+	report, err := n.mergeTestReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return dag.
+		Directory().
+		WithNewFile(mergedReportName, report).
+		File(mergedReportName), nil
+}
+
+// Return a summary of the junit reports written by the test runner
+func (n *Node) TestSummary(ctx context.Context) (*TestSummary, error) {
+	report, err := n.mergeTestReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return summarizeTestReport(report)
+}
+
+// runTests run a test command and keep the container when the tests fail so the reports can be read, a TestFailureError is returned on failure
+func (n *Node) runTests(ctx context.Context, cmd []string, captureOutput bool) error {
+	n.Ctr = n.Ctr.WithExec(execCommand(cmd, captureOutput), dagger.ContainerWithExecOpts{
+		Expect: dagger.ReturnTypeAny,
+	})
+
+	exitCode, err := n.Ctr.ExitCode(ctx)
+	if err != nil {
+		return err
+	}
+
+	if exitCode == 0 {
+		return nil
+	}
+
+	stdout, err := n.Ctr.Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	stderr, err := n.Ctr.Stderr(ctx)
+	if err != nil {
+		return err
+	}
+
+	failure := &TestFailureError{
+		ExitCode:     exitCode,
+		FailingTests: []string{},
+		Output:       stdout + stderr,
+	}
+
+	// The runner may have crashed before writing its report, the failure is returned without the failing tests
+	if n.TestReportEnabled {
+		summary, err := n.TestSummary(ctx)
+		if err == nil {
+			failure.FailingTests = summary.FailingTests
+		}
+	}
+
+	return failure
+}
+
+// summarizeTestReport count the test cases of a merged junit report
+func summarizeTestReport(report string) (*TestSummary, error) {
+	suites := junitTestSuites{}
+	err := xml.Unmarshal([]byte(report), &suites)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &TestSummary{FailingTests: []string{}}
+	for _, suite := range suites.Suites {
+		summary.add(suite)
+	}
+
+	return summary, nil
+}
+
+func (s *TestSummary) add(suite junitTestSuite) {
+	for _, testCase := range suite.Cases {
+		switch {
+		case testCase.Failure != nil || testCase.Error != nil:
+			s.Failed++
+			s.FailingTests = append(s.FailingTests, testCase.fullName())
+		case testCase.Skipped != nil:
+			s.Skipped++
+		default:
+			s.Passed++
+		}
+	}
+
+	for _, child := range suite.Suites {
+		s.add(child)
+	}
+}
+
+func (c junitTestCase) fullName() string {
+	if c.ClassName == "" || c.ClassName == c.Name {
+		return c.Name
+	}
+
+	return c.ClassName + " > " + c.Name
+}
+
+// mergeTestReports concatenate every test suites of the reports under a single 'testsuites' element
+func (n *Node) mergeTestReports(ctx context.Context) (string, error) {
+	entries, err := n.Ctr.Directory(reportsDir).Entries(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var suites []string
+	for _, entry := range entries {
+		if filepath.Ext(entry) != ".xml" {
+			continue
+		}
+
+		content, err := n.Ctr.File(reportsDir + "/" + entry).Contents(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		root := junitRoot{}
+		err = xml.Unmarshal([]byte(content), &root)
+		if err != nil {
+			return "", fmt.Errorf("not able to parse the test report '%s': %w", entry, err)
+		}
+
+		if root.XMLName.Local == "testsuites" {
+			suites = append(suites, root.Inner)
+		} else {
+			suites = append(suites, xmlHeaderRe.ReplaceAllString(content, ""))
+		}
+	}
+
+	return xml.Header + "<testsuites>" + strings.Join(suites, "\n") + "</testsuites>\n", nil
+}
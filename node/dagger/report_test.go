@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeTestReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  string
+		want    TestSummary
+		wantErr bool
+	}{
+		{
+			name: "passing",
+			report: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="basic.test.ts">
+    <testcase classname="basic" name="adds"/>
+    <testcase classname="basic" name="skips"><skipped/></testcase>
+  </testsuite>
+</testsuites>`,
+			want: TestSummary{Passed: 1, Skipped: 1, FailingTests: []string{}},
+		},
+		{
+			name: "failing shards",
+			report: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="shard 1">
+    <testcase classname="app" name="status"><failure message="expected 200"/></testcase>
+    <testcase classname="app" name="health"/>
+  </testsuite>
+  <testsuite name="shard 2">
+    <testcase classname="sum" name="sum"><error message="TypeError"/></testcase>
+  </testsuite>
+</testsuites>`,
+			want: TestSummary{Passed: 1, Failed: 2, FailingTests: []string{"app > status", "sum"}},
+		},
+		{
+			name: "nested node test suites",
+			report: `<testsuites>
+  <testsuite name="app">
+    <testsuite name="routes">
+      <testcase name="get" classname="get"><failure/></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`,
+			want: TestSummary{Failed: 1, FailingTests: []string{"get"}},
+		},
+		{
+			name:    "invalid",
+			report:  "<testsuites><testsuite>",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := summarizeTestReport(tt.report)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("summarizeTestReport() = %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("summarizeTestReport() returned an error: %v", err)
+			}
+
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("summarizeTestReport() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTestFailureError(t *testing.T) {
+	failure := &TestFailureError{
+		ExitCode:     1,
+		FailingTests: []string{"app > status", "sum"},
+		Output:       "2 failed",
+	}
+
+	msg := failure.Error()
+	for _, want := range []string{"exit code 1", "  app > status\n  sum", "2 failed"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Error() = %q, want it to contain %q", msg, want)
+		}
+	}
+
+	withoutReport := &TestFailureError{ExitCode: 2, Output: "crashed"}
+	if strings.Contains(withoutReport.Error(), "failing tests") {
+		t.Errorf("Error() = %q, want no failing tests", withoutReport.Error())
+	}
+
+	if !isTestFailure(fmt.Errorf("shard 1/2 failed: %w", failure)) {
+		t.Errorf("isTestFailure() = false for a wrapped test failure")
+	}
+
+	if isTestFailure(errors.New("engine error")) {
+		t.Errorf("isTestFailure() = true for another error")
+	}
+}
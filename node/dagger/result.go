@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/sync/errgroup"
+	"main/internal/dagger"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	stageSuccess = "success"
+	stageFailure = "failure"
+	stageSkipped = "skipped"
+
+	packageDir = "/outputs/package"
+)
+
+// A stage of the pipeline
+type PipelineStage struct {
+	// The name of the stage (install | audit | lint | test | build | publish | oci) or of the hook point
+	Name string
+	// The status of the stage (success | failure | skipped)
+	Status string
+	// The duration of the stage
+	Duration string
+	// The output of the last command of the stage
+	Output string
+	// The exit code of the last command of the stage
+	ExitCode int
+	// The artifacts produced by the stage, like the published package and image refs
+	Artifacts []string
+}
+
+// The result of the pipeline with each stage which ran
+type PipelineResult struct {
+	// The stages in the order of execution
+	Stages []PipelineStage
+	// The container at the end of the pipeline
+	Container *dagger.Container
+	// The package tarball with its checksum and signature when a package is published
+	Package *PackageArtifacts
+}
+
+// Indicate every stage succeeded or was skipped
+func (r *PipelineResult) Succeeded() bool {
+	for _, stage := range r.Stages {
+		if stage.Status == stageFailure {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Return the stage with this name, the first one when the stage ran several times like the hooks
+func (r *PipelineResult) Stage(
+	// The name of the stage (install | audit | lint | test | build | publish | oci) or of the hook point
+	name string,
+) (*PipelineStage, error) {
+	for idx, stage := range r.Stages {
+		if stage.Name == name {
+			return &r.Stages[idx], nil
+		}
+	}
+
+	return nil, fmt.Errorf("the stage '%s' didn't run", name)
+}
+
+// Return the package and image refs published by the pipeline
+func (r *PipelineResult) Refs() []string {
+	refs := []string{}
+	for _, stage := range r.Stages {
+		if stage.Name == "publish" || stage.Name == "oci" {
+			refs = append(refs, stage.Artifacts...)
+		}
+	}
+
+	return refs
+}
+
+// Return a table with the status and the duration of each stage
+func (r *PipelineResult) Summary() string {
+	var summary strings.Builder
+
+	writer := tabwriter.NewWriter(&summary, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "STAGE\tSTATUS\tDURATION\tEXIT CODE")
+	for _, stage := range r.Stages {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\n", stage.Name, stage.Status, stage.Duration, stage.ExitCode)
+	}
+	_ = writer.Flush()
+
+	return summary.String()
+}
+
+// skip record the stages as skipped
+func (r *PipelineResult) skip(names ...string) {
+	for _, name := range names {
+		r.Stages = append(r.Stages, PipelineStage{
+			Name:      name,
+			Status:    stageSkipped,
+			Artifacts: []string{},
+		})
+	}
+}
+
+// runStage run the stage, execute the commands it added to the container and record its output, exit code, duration and artifacts,
+// a stage without container returns a nil node
+func (r *PipelineResult) runStage(ctx context.Context, name string, stage func() (*Node, []string, error)) (*Node, error) {
+	start := time.Now()
+
+	result := PipelineStage{
+		Name:      name,
+		Status:    stageSuccess,
+		Artifacts: []string{},
+	}
+
+	n, artifacts, err := stage()
+	if err == nil && n != nil {
+		_, err = n.Ctr.Sync(ctx)
+	}
+
+	if err == nil && n != nil {
+		result.Output, _ = n.Ctr.Stdout(ctx)
+	}
+
+	if artifacts != nil {
+		result.Artifacts = artifacts
+	}
+
+	if err != nil {
+		result.Status = stageFailure
+		result.ExitCode = 1
+		result.Output = err.Error()
+
+		var execErr *dagger.ExecError
+		if errors.As(err, &execErr) {
+			result.ExitCode = execErr.ExitCode
+			result.Output = execErr.Stdout + execErr.Stderr
+		}
+
+		var testErr *TestFailureError
+		if errors.As(err, &testErr) {
+			result.ExitCode = testErr.ExitCode
+			result.Output = testErr.Error()
+		}
+	}
+
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	r.Stages = append(r.Stages, result)
+
+	if err != nil {
+		return nil, fmt.Errorf("the stage '%s' failed: %w", name, err)
+	}
+
+	return n, nil
+}
+
+// runParallel run the stages in their own container from the same node, their stages are recorded in order and their outputs are merged
+func (r *PipelineResult) runParallel(pipeline *Node, stages ...func(*PipelineResult, *Node) (*Node, error)) (*Node, error) {
+	var eg errgroup.Group
+
+	results := make([]*PipelineResult, len(stages))
+	nodes := make([]*Node, len(stages))
+	errs := make([]error, len(stages))
+	for idx, stage := range stages {
+		results[idx] = &PipelineResult{}
+		eg.Go(func() error {
+			nodes[idx], errs[idx] = stage(results[idx], pipeline.fork())
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	merged := pipeline.fork()
+	for idx := range stages {
+		r.Stages = append(r.Stages, results[idx].Stages...)
+
+		if nodes[idx] != nil {
+			merged.Ctr = merged.Ctr.WithDirectory("/outputs", nodes[idx].Ctr.Directory("/outputs"))
+		}
+	}
+
+	return merged, errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"main/internal/dagger"
+	"slices"
+	"strings"
+)
+
+const (
+	syftImage     = "anchore/syft:v1.18.1"
+	orasImage     = "ghcr.io/oras-project/oras:v1.2.0"
+	sbomName      = "sbom.json"
+	sbomScanDir   = "/scan"
+	sbomOutputDir = "/outputs"
+)
+
+// The syft output and the media type of the supported sbom formats
+var sbomFormats = map[string]struct {
+	syftOutput string
+	mediaType  string
+}{
+	"cyclonedx": {syftOutput: "cyclonedx-json", mediaType: "application/vnd.cyclonedx+json"},
+	"spdx":      {syftOutput: "spdx-json", mediaType: "application/spdx+json"},
+}
+
+// Generate a sbom for the package published by 'publish' and the images built by 'oci-build' and 'docker-build', the sbom is attached to the images as an OCI referrer
+func (n *Node) WithSbom(
+	// The sbom format (cyclonedx | spdx)
+	// +optional
+	// +default="cyclonedx"
+	format string,
+	// A docker config.json with the credentials of the registries, used to attach the sbom to the images
+	// +optional
+	dockerConfig *dagger.Secret,
+) (*Node, error) {
+	if _, ok := sbomFormats[format]; !ok {
+		return nil, fmt.Errorf("unsupported sbom format '%s' (cyclonedx | spdx)", format)
+	}
+
+	n.SbomFormat = format
+	n.DockerConfig = dockerConfig
+
+	return n, nil
+}
+
+// Return a sbom of the project from the lockfile and the installed node modules
+func (n *Node) Sbom(
+	// The sbom format (cyclonedx | spdx)
+	// +optional
+	// +default="cyclonedx"
+	format string,
+) (*dagger.File, error) {
+	if _, ok := sbomFormats[format]; !ok {
+		return nil, fmt.Errorf("unsupported sbom format '%s' (cyclonedx | spdx)", format)
+	}
+
+	return generateSbom(n.Ctr.Directory(workdir), format), nil
+}
+
+// generateSbom scan the directory with syft
+func generateSbom(dir *dagger.Directory, format string) *dagger.File {
+	return dag.
+		Container().
+		From(syftImage).
+		WithMountedDirectory(sbomScanDir, dir).
+		WithExec([]string{
+			"/syft",
+			"scan",
+			"dir:" + sbomScanDir,
+			"--output", sbomFormats[format].syftOutput + "=/tmp/" + sbomName,
+		}).
+		File("/tmp/" + sbomName)
+}
+
+// sbomSubjects return the image digests of the published refs, each digest of a repository appears once whatever the number of tags
+func sbomSubjects(refs []string) []string {
+	var subjects []string
+	for _, ref := range refs {
+		name, digest, found := strings.Cut(ref, "@")
+		if !found {
+			continue
+		}
+
+		// The tag is removed, the colon of a registry port is before the last slash
+		if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+			name = name[:idx]
+		}
+
+		subject := name + "@" + digest
+		if !slices.Contains(subjects, subject) {
+			subjects = append(subjects, subject)
+		}
+	}
+
+	return subjects
+}
+
+// attachSbom push the sbom as an OCI artifact referring to the published image
+func (n *Node) attachSbom(ctx context.Context, ref string, sbom *dagger.File) error {
+	ctr := dag.
+		Container().
+		From(orasImage).
+		WithMountedFile("/tmp/"+sbomName, sbom).
+		WithWorkdir("/tmp")
+
+	if n.DockerConfig != nil {
+		ctr = ctr.WithMountedSecret("/root/.docker/config.json", n.DockerConfig)
+	}
+
+	_, err := ctr.
+		WithExec([]string{
+			"oras",
+			"attach",
+			"--artifact-type", sbomFormats[n.SbomFormat].mediaType,
+			ref,
+			sbomName + ":" + sbomFormats[n.SbomFormat].mediaType,
+		}).
+		Sync(ctx)
+
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSbomSubjects(t *testing.T) {
+	tests := []struct {
+		name string
+		refs []string
+		want []string
+	}{
+		{
+			name: "tags of the same digest",
+			refs: []string{
+				"ghcr.io/my-org/myapi:1.2.3@sha256:aaa",
+				"ghcr.io/my-org/myapi:1.2@sha256:aaa",
+				"ghcr.io/my-org/myapi:latest@sha256:aaa",
+			},
+			want: []string{"ghcr.io/my-org/myapi@sha256:aaa"},
+		},
+		{
+			name: "several registries",
+			refs: []string{
+				"ghcr.io/my-org/myapi:1.2.3@sha256:aaa",
+				"registry.example.com:5000/myapi:1.2.3@sha256:aaa",
+				"registry.example.com:5000/myapi:latest@sha256:aaa",
+			},
+			want: []string{"ghcr.io/my-org/myapi@sha256:aaa", "registry.example.com:5000/myapi@sha256:aaa"},
+		},
+		{
+			name: "without digest",
+			refs: []string{"ttl.sh/myapi:60m"},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sbomSubjects(tt.refs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sbomSubjects(%v) = %v, want %v", tt.refs, got, tt.want)
+			}
+		})
+	}
+}
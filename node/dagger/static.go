@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"main/internal/dagger"
+)
+
+const (
+	staticSitePort = 80
+)
+
+// The output directory of the build of each supported static site framework
+var staticSiteOutputDirs = map[string]string{
+	"astro": "dist",
+	"next":  "out",
+	"vite":  "dist",
+	"cra":   "build",
+}
+
+// The web servers able to serve the static site with the directory served by their default configuration
+var staticSiteServers = map[string]struct {
+	image string
+	root  string
+}{
+	"nginx": {image: "nginx:stable-alpine-slim", root: "/usr/share/nginx/html"},
+	"caddy": {image: "caddy:alpine", root: "/usr/share/caddy"},
+}
+
+// Build the static site and return the output directory, the framework is detected from the dependencies (astro | next | vite | cra), next requires the "output: 'export'" option
+func (n *Node) StaticSite(
+	ctx context.Context,
+	// The framework of the site (astro | next | vite | cra), detected by default
+	// +optional
+	framework string,
+	// The directory of the build output relative to the source, the one of the framework is used by default
+	// +optional
+	outputDir string,
+) (*dagger.Directory, error) {
+	outputDir, err := n.staticSiteOutputDir(ctx, framework, outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	site := n.Build(false).Ctr.Directory(workdir + "/" + outputDir)
+
+	_, err = site.Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("the build didn't produce the '%s' directory: %w", outputDir, err)
+	}
+
+	return site, nil
+}
+
+// Return a tiny web server image serving the static site on the port 80
+func (n *Node) StaticSiteImage(
+	ctx context.Context,
+	// The framework of the site (astro | next | vite | cra), detected by default
+	// +optional
+	framework string,
+	// The directory of the build output relative to the source, the one of the framework is used by default
+	// +optional
+	outputDir string,
+	// The web server to use (nginx | caddy)
+	// +optional
+	// +default="nginx"
+	server string,
+) (*dagger.Container, error) {
+	webServer, ok := staticSiteServers[server]
+	if !ok {
+		return nil, fmt.Errorf("unsupported web server '%s' (nginx | caddy)", server)
+	}
+
+	site, err := n.StaticSite(ctx, framework, outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr := dag.
+		Container(dagger.ContainerOpts{
+			Platform: n.Platform,
+		}).
+		From(webServer.image).
+		WithoutDirectory(webServer.root).
+		WithDirectory(webServer.root, site).
+		WithExposedPort(staticSitePort)
+
+	return n.withImageMetadata(ctr), nil
+}
+
+// Serve the static site with a web server for previews
+func (n *Node) StaticSiteServe(
+	ctx context.Context,
+	// The framework of the site (astro | next | vite | cra), detected by default
+	// +optional
+	framework string,
+	// The directory of the build output relative to the source, the one of the framework is used by default
+	// +optional
+	outputDir string,
+	// The web server to use (nginx | caddy)
+	// +optional
+	// +default="nginx"
+	server string,
+) (*dagger.Service, error) {
+	ctr, err := n.StaticSiteImage(ctx, framework, outputDir, server)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctr.AsService(), nil
+}
+
+// staticSiteOutputDir return the output directory of the framework, the framework is detected when not given
+func (n *Node) staticSiteOutputDir(ctx context.Context, framework string, outputDir string) (string, error) {
+	if outputDir != "" {
+		return outputDir, nil
+	}
+
+	if framework == "" {
+		framework = n.StaticFramework
+	}
+
+	if framework == "" && n.Src != nil {
+		var err error
+		framework, err = n.
+			nodeAnalyzer(n.Src).
+			GetStaticSiteFramework(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if framework == "" {
+		return "", fmt.Errorf("no static site framework detected, the output directory has to be defined")
+	}
+
+	outputDir, ok := staticSiteOutputDirs[framework]
+	if !ok {
+		return "", fmt.Errorf("unsupported static site framework '%s' (astro | next | vite | cra)", framework)
+	}
+
+	return outputDir, nil
+}
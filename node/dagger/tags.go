@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+var invalidTagCharsRe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// The data available in the custom tag templates
+type tagTemplateData struct {
+	Name     string
+	Version  string
+	Major    string
+	Minor    string
+	Patch    string
+	Revision string
+	Branch   string
+}
+
+// Define how the images built by 'oci-build' and 'docker-build' are tagged
+func (n *Node) WithImageTags(
+	// The tag strategies to use (version | semver | sha | branch | latest), 'version' is used by default
+	// +optional
+	strategies []string,
+	// Custom tag templates using the fields .Name, .Version, .Major, .Minor, .Patch, .Revision and .Branch (e.g. "{{ .Version }}-{{ .Revision }}")
+	// +optional
+	templates []string,
+	// The git revision used by the 'sha' strategy and the revision label
+	// +optional
+	revision string,
+	// The git branch used by the 'branch' strategy
+	// +optional
+	branch string,
+) (*Node, error) {
+	for _, strategy := range strategies {
+		if !slices.Contains([]string{"version", "semver", "sha", "branch", "latest"}, strategy) {
+			return nil, fmt.Errorf("unsupported tag strategy '%s' (version | semver | sha | branch | latest)", strategy)
+		}
+	}
+
+	for _, tmpl := range templates {
+		_, err := template.New("tag").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("not able to parse the tag template '%s': %w", tmpl, err)
+		}
+	}
+
+	n.TagStrategies = strategies
+	n.TagTemplates = templates
+	n.Revision = revision
+	n.Branch = branch
+
+	return n, nil
+}
+
+// imageTags return the tags to publish according to the tag strategies and templates
+func (n *Node) imageTags() ([]string, error) {
+	strategies := n.TagStrategies
+	if len(strategies) == 0 && len(n.TagTemplates) == 0 {
+		strategies = []string{"version"}
+	}
+
+	versionCore, _, _ := strings.Cut(n.Version, "-")
+	versionParts := append(strings.SplitN(versionCore, ".", 3), "", "", "")
+	isPrerelease := strings.Contains(n.Version, "-")
+
+	var tags []string
+	for _, strategy := range strategies {
+		switch strategy {
+		case "version":
+			tags = append(tags, n.Version)
+		case "semver":
+			// A prerelease must not move the major and minor tags
+			if !isPrerelease && versionParts[1] != "" {
+				tags = append(tags, versionParts[0], versionParts[0]+"."+versionParts[1])
+			}
+			tags = append(tags, n.Version)
+		case "sha":
+			if n.Revision == "" {
+				return nil, fmt.Errorf("the 'sha' tag strategy requires a revision")
+			}
+			tags = append(tags, n.Revision)
+		case "branch":
+			if n.Branch == "" {
+				return nil, fmt.Errorf("the 'branch' tag strategy requires a branch")
+			}
+			tags = append(tags, n.Branch)
+		case "latest":
+			tags = append(tags, "latest")
+		}
+	}
+
+	data := tagTemplateData{
+		Name:     n.Name,
+		Version:  n.Version,
+		Major:    versionParts[0],
+		Minor:    versionParts[1],
+		Patch:    versionParts[2],
+		Revision: n.Revision,
+		Branch:   n.Branch,
+	}
+	for _, tmpl := range n.TagTemplates {
+		var tag bytes.Buffer
+		err := template.Must(template.New("tag").Parse(tmpl)).Execute(&tag, data)
+		if err != nil {
+			return nil, fmt.Errorf("not able to render the tag template '%s': %w", tmpl, err)
+		}
+		tags = append(tags, tag.String())
+	}
+
+	var sanitizedTags []string
+	for _, tag := range tags {
+		tag = strings.TrimLeft(invalidTagCharsRe.ReplaceAllString(tag, "-"), ".-")
+		if len(tag) > 128 {
+			tag = tag[:128]
+		}
+
+		if tag != "" && !slices.Contains(sanitizedTags, tag) {
+			sanitizedTags = append(sanitizedTags, tag)
+		}
+	}
+
+	return sanitizedTags, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImageTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		node    Node
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "version by default",
+			node: Node{Version: "1.2.3"},
+			want: []string{"1.2.3"},
+		},
+		{
+			name: "semver",
+			node: Node{Version: "1.2.3", TagStrategies: []string{"semver"}},
+			want: []string{"1", "1.2", "1.2.3"},
+		},
+		{
+			name: "semver prerelease",
+			node: Node{Version: "2.0.0-rc.1", TagStrategies: []string{"semver"}},
+			want: []string{"2.0.0-rc.1"},
+		},
+		{
+			name: "sha branch and latest",
+			node: Node{
+				Version:       "1.2.3",
+				Revision:      "4f2a9c1",
+				Branch:        "main",
+				TagStrategies: []string{"version", "sha", "branch", "latest"},
+			},
+			want: []string{"1.2.3", "4f2a9c1", "main", "latest"},
+		},
+		{
+			name: "sanitized branch",
+			node: Node{Branch: "feature/Add login", TagStrategies: []string{"branch"}},
+			want: []string{"feature-Add-login"},
+		},
+		{
+			name: "templates only",
+			node: Node{
+				Name:         "myapi",
+				Version:      "1.2.3",
+				Revision:     "4f2a9c1",
+				TagTemplates: []string{"{{ .Major }}.{{ .Minor }}-{{ .Revision }}", "{{ .Name }}-{{ .Patch }}"},
+			},
+			want: []string{"1.2-4f2a9c1", "myapi-3"},
+		},
+		{
+			name: "duplicates removed",
+			node: Node{Version: "1.2.3", TagStrategies: []string{"version", "semver"}},
+			want: []string{"1.2.3", "1", "1.2"},
+		},
+		{
+			name: "leading separators trimmed",
+			node: Node{Branch: ".-hotfix", TagStrategies: []string{"branch"}},
+			want: []string{"hotfix"},
+		},
+		{
+			name: "truncated",
+			node: Node{Branch: strings.Repeat("a", 200), TagStrategies: []string{"branch"}},
+			want: []string{strings.Repeat("a", 128)},
+		},
+		{
+			name:    "sha without revision",
+			node:    Node{Version: "1.2.3", TagStrategies: []string{"sha"}},
+			wantErr: true,
+		},
+		{
+			name:    "branch without branch",
+			node:    Node{Version: "1.2.3", TagStrategies: []string{"branch"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.node.imageTags()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("imageTags() = %v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("imageTags() returned an error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("imageTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,65 @@
 package main
 
-import "main/internal/dagger"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"main/internal/dagger"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The lockfiles hashed in the 'node_modules' cache keys
+var lockfiles = []string{
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"bun.lockb",
+	"bun.lock",
+}
+
+// The lockfiles of each package manager
+var packageManagerLockfiles = map[string][]string{
+	"npm":  {"package-lock.json", "npm-shrinkwrap.json"},
+	"yarn": {"yarn.lock"},
+	"pnpm": {"pnpm-lock.yaml"},
+	"bun":  {"bun.lock", "bun.lockb"},
+}
+
+var shellSafeArgRe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellJoin join the arguments in a shell command line, quoting the ones with special characters
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if shellSafeArgRe.MatchString(arg) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// nodeAnalyzer return the autodetection analyzer of the source with the options given to 'with-auto-setup'
+func (n *Node) nodeAnalyzer(src *dagger.Directory) *dagger.AutodetectionNodeAnalyzer {
+	return dag.
+		Autodetection().
+		Node(
+			src,
+			dagger.AutodetectionNodeOpts{
+				PatternExclusions: append(
+					[]string{"node_modules"},
+					n.AnalyzerPatternExclusions...,
+				),
+				InternalImage:    n.AnalyzerInternalImage,
+				NodeVersionIndex: n.AnalyzerNodeVersionIndex,
+			},
+		)
+}
 
 // Return the current container state
 func (n *Node) Container() *dagger.Container {
@@ -45,3 +104,40 @@ func (n *Node) getCacheKey(cacheKey string) string {
 
 	return cacheKey
 }
+
+// getModulesCacheKey return the cache key of a 'node_modules' folder, scoped to the lockfile hash when enabled
+func (n *Node) getModulesCacheKey(cacheKey string) string {
+	cacheKey = n.getCacheKey(cacheKey)
+
+	if n.LockfileHash != "" {
+		cacheKey = cacheKey + "-" + n.LockfileHash
+	}
+
+	return cacheKey
+}
+
+// lockfileHash return a short hash of the lockfiles of the source, the package manager and the node version
+func (n *Node) lockfileHash(ctx context.Context, src *dagger.Directory) (string, error) {
+	entries, err := src.Entries(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	hashInputs := []string{n.PkgMgr, n.BaseImageRef}
+	for _, lockfile := range lockfiles {
+		if !slices.Contains(entries, lockfile) {
+			continue
+		}
+
+		digest, err := src.File(lockfile).Digest(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		hashInputs = append(hashInputs, lockfile+"="+digest)
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(hashInputs, "\n")))
+
+	return hex.EncodeToString(hash[:])[:12], nil
+}
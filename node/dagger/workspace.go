@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"main/internal/dagger"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// An artifact produced for a workspace of a monorepo
+type WorkspaceArtifact struct {
+	// The name of the workspace package
+	Workspace string
+	// The reference of the artifact, 'name@version' for a package or the fully qualified image name for an image
+	Ref string
+}
+
+// workspacePackage is the information of a workspace package resolved by the autodetection
+type workspacePackage struct {
+	Name       string
+	Version    string
+	Path       string
+	Private    bool
+	Dockerfile string
+	Scripts    []string
+}
+
+// Publish each selected workspace package to the registry with its own name and version, the private packages are skipped
+func (n *Node) PublishWorkspaces(
+	ctx context.Context,
+	// The workspaces to publish, the workspaces of the pipeline or all of them are used by default
+	// +optional
+	workspaces []string,
+	// Define permission on the package in the registry
+	// +optional
+	access string,
+	// Indicate if the package is publishing as development version
+	// +optional
+	devTag string,
+	// Indicate to dry run the publishing
+	// +optional
+	dryRun bool,
+) ([]WorkspaceArtifact, error) {
+	packages, err := n.selectedWorkspacePackages(ctx, workspaces)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := []WorkspaceArtifact{}
+	ctr := n.Ctr
+	for _, pkg := range packages {
+		if pkg.Private {
+			continue
+		}
+
+		ctr = ctr.
+			WithWorkdir(workdir + "/" + pkg.Path).
+			WithExec(n.publishCommand(access, devTag, dryRun))
+
+		artifacts = append(artifacts, WorkspaceArtifact{
+			Workspace: pkg.Name,
+			Ref:       pkg.Name + "@" + pkg.Version,
+		})
+	}
+
+	_, err = ctr.Sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return artifacts, nil
+}
+
+// Build an image for each selected workspace having a Dockerfile or marked as deployable and push them to one or more registries, the image is named after the workspace package.
+// The Dockerfiles are built with the root of the monorepo as context to access the shared packages, their COPY paths are relative to the root
+func (n *Node) WorkspaceOciBuild(
+	ctx context.Context,
+	// Define registries where to push the images
+	registries []string,
+	// The workspaces without Dockerfile to build with the production image of the module, the application is in its workspace directory
+	// +optional
+	deployable []string,
+	// The workspaces to build, the workspaces of the pipeline or all of them are used by default
+	// +optional
+	workspaces []string,
+	// Build arguments to pass to the Dockerfile builds (KEY=VALUE)
+	// +optional
+	buildArgs []string,
+	// The target stage to build in the Dockerfiles
+	// +optional
+	target string,
+	// Secrets to expose to the Dockerfile builds, they are available with their name as id
+	// +optional
+	secrets []*dagger.Secret,
+	// Define the ttl registry to use
+	// +optional
+	isTtl bool,
+	// Define the ttl registry to use
+	// +optional
+	// +default="ttl.sh"
+	ttlRegistry string,
+	// Define the ttl in the ttl registry
+	// +optional
+	// +default="60m"
+	ttl string,
+	// Define the platforms to build the images for (e.g. linux/amd64, linux/arm64)
+	// +optional
+	platforms []dagger.Platform,
+	// Install exactly the lockfile in the production images and fail when it is out of sync with the package.json
+	// +optional
+	// +default=true
+	frozenLockfile bool,
+) ([]WorkspaceArtifact, error) {
+	packages, err := n.selectedWorkspacePackages(ctx, workspaces)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(platforms) == 0 {
+		platforms = []dagger.Platform{n.Platform}
+	}
+
+	artifacts := []WorkspaceArtifact{}
+	for _, pkg := range packages {
+		if pkg.Dockerfile == "" && !slices.Contains(deployable, pkg.Name) {
+			continue
+		}
+
+		// The image ref and the metadata use the name and the version of the workspace
+		workspace := n.fork()
+		workspace.Name = strings.ToLower(strings.TrimPrefix(pkg.Name, "@"))
+		workspace.Version = pkg.Version
+
+		var refs []string
+		if pkg.Dockerfile != "" {
+			refs, err = workspace.DockerBuild(ctx, registries, pkg.Dockerfile, buildArgs, target, secrets, isTtl, ttlRegistry, ttl, platforms)
+		} else {
+			refs, err = workspace.workspaceOciBuild(ctx, pkg, registries, isTtl, ttlRegistry, ttl, platforms, frozenLockfile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not able to build the image of the workspace '%s': %w", pkg.Name, err)
+		}
+
+		for _, ref := range refs {
+			artifacts = append(artifacts, WorkspaceArtifact{
+				Workspace: pkg.Name,
+				Ref:       ref,
+			})
+		}
+	}
+
+	return artifacts, nil
+}
+
+// workspaceOciBuild build the production image of the monorepo with the workspace directory as working directory
+func (n *Node) workspaceOciBuild(
+	ctx context.Context,
+	pkg workspacePackage,
+	registries []string,
+	isTtl bool,
+	ttlRegistry string,
+	ttl string,
+	platforms []dagger.Platform,
+	frozenLockfile bool,
+) ([]string, error) {
+	err := n.checkRuntimePlatforms(ctx, platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every workspaces are kept as the package managers need them to install from the root lockfile
+	src := dag.
+		Directory().
+		WithDirectory(".", n.Ctr.Directory(workdir), dagger.DirectoryWithDirectoryOpts{
+			Exclude: []string{"**/node_modules", ".git"},
+		})
+
+	platformVariants := make([]*dagger.Container, len(platforms))
+	for idx, platform := range platforms {
+		productionBuild, err := n.productionBuild(ctx, platform, src, frozenLockfile)
+		if err != nil {
+			return nil, err
+		}
+
+		platformVariants[idx] = n.
+			runtimeContainer(platform, productionBuild).
+			WithWorkdir(workdir + "/" + pkg.Path)
+	}
+
+	return n.publishImage(ctx, platformVariants, registries, isTtl, ttlRegistry, ttl)
+}
+
+// selectedWorkspacePackages return the workspace packages matching the given names, the workspaces of the pipeline or all of them
+func (n *Node) selectedWorkspacePackages(ctx context.Context, workspaces []string) ([]workspacePackage, error) {
+	if n.Src == nil {
+		return nil, fmt.Errorf("the source has to be set with 'with-source' to find the workspaces")
+	}
+
+	if workspaces == nil {
+		workspaces = n.Workspaces
+	}
+
+	detected, err := n.
+		nodeAnalyzer(n.Src).
+		GetWorkspacePackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []workspacePackage
+	for _, detectedPkg := range detected {
+		pkg := workspacePackage{}
+
+		pkg.Name, err = detectedPkg.Name(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pkg.Path, err = detectedPkg.Path(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// The workspaces can be selected by package name or by directory
+		if workspaces != nil && !slices.Contains(workspaces, pkg.Name) && !slices.Contains(workspaces, pkg.Path) && !slices.Contains(workspaces, filepath.Base(pkg.Path)) {
+			continue
+		}
+
+		pkg.Version, err = detectedPkg.Version(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pkg.Private, err = detectedPkg.Private(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pkg.Dockerfile, err = detectedPkg.Dockerfile(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pkg.Scripts, err = detectedPkg.Scripts(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		packages = append(packages, pkg)
+	}
+
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no workspace package found in the source")
+	}
+
+	return packages, nil
+}
+
+// workspacesWithScript return the name of the workspace packages defining the script, the package managers fail to run a script missing in a selected workspace
+func workspacesWithScript(packages []workspacePackage, script string) []string {
+	workspaces := []string{}
+	for _, pkg := range packages {
+		if slices.Contains(pkg.Scripts, script) {
+			workspaces = append(workspaces, pkg.Name)
+		}
+	}
+
+	return workspaces
+}
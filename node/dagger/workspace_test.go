@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWorkspacesWithScript(t *testing.T) {
+	packages := []workspacePackage{
+		{Name: "@acme/api", Scripts: []string{"build", "start", "test"}},
+		{Name: "@acme/core", Scripts: []string{"build", "lint", "test"}},
+		{Name: "@acme/docs", Scripts: nil},
+	}
+
+	tests := []struct {
+		script string
+		want   []string
+	}{
+		{script: "build", want: []string{"@acme/api", "@acme/core"}},
+		{script: "lint", want: []string{"@acme/core"}},
+		{script: "e2e", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			got := workspacesWithScript(packages, tt.script)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("workspacesWithScript(%s) = %v, want %v", tt.script, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+import assert from "node:assert/strict";
+import { after, before, test } from "node:test";
+import { app } from "../src/app.js";
+
+before(() => new Promise((resolve) => app.listen(0, resolve)));
+after(() => app.close());
+
+test("status", async () => {
+  const res = await fetch(`http://localhost:${app.address().port}`);
+  assert.equal(res.status, 200);
+  assert.deepEqual(await res.json(), { status: "ok" });
+});
@@ -0,0 +1,8 @@
+import { createServer } from "node:http";
+import { greet } from "@dudesons/example-core";
+
+const port = process.env.PORT || 3000;
+
+createServer((req, res) => {
+  res.end(greet("world"));
+}).listen(port);
@@ -0,0 +1,7 @@
+import assert from "node:assert/strict";
+import { test } from "node:test";
+import { greet } from "@dudesons/example-core";
+
+test("greet from the core workspace", () => {
+  assert.equal(greet("api"), "Hello api");
+});
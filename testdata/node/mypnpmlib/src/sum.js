@@ -0,0 +1,3 @@
+export function sum(...numbers) {
+  return numbers.reduce((total, n) => total + n, 0);
+}
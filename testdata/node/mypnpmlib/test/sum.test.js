@@ -0,0 +1,11 @@
+import assert from "node:assert/strict";
+import { test } from "node:test";
+import { sum } from "../src/sum.js";
+
+test("sum of numbers", () => {
+  assert.equal(sum(1, 2, 3), 6);
+});
+
+test("sum of nothing", () => {
+  assert.equal(sum(), 0);
+});